@@ -0,0 +1,289 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"go/ast"
+	"go/doc"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// Analysis is the document parsed and type-checked as far as it goes,
+// packages are imported from the standard library of the toolchain.
+// Editor features like completion are built on it.
+type Analysis struct {
+	Fset *token.FileSet
+	File *ast.File
+	Src  []byte
+	Pkg  *types.Package
+	Info *types.Info
+
+	tc Toolchain
+}
+
+// Analyze parses and type-checks the document. It only fails when
+// nothing of the document parses or it has no package clause, type
+// errors are ignored.
+func Analyze(ctx context.Context, tc Toolchain, src []byte) (*Analysis, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, progFile, src, parser.AllErrors|parser.ParseComments)
+	if f == nil {
+		return nil, err
+	}
+	// Positions of the document are found through its package clause.
+	if !f.Package.IsValid() {
+		return nil, errors.New("the document has no package clause")
+	}
+
+	a := &Analysis{
+		Fset: fset,
+		File: f,
+		Src:  src,
+		Info: &types.Info{
+			Types:      make(map[ast.Expr]types.TypeAndValue),
+			Defs:       make(map[*ast.Ident]types.Object),
+			Uses:       make(map[*ast.Ident]types.Object),
+			Implicits:  make(map[ast.Node]types.Object),
+			Selections: make(map[*ast.SelectorExpr]*types.Selection),
+			Scopes:     make(map[ast.Node]*types.Scope),
+		},
+		tc: tc,
+	}
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "gc", exportLookup(ctx, tc)),
+		// The document is usually being edited, errors are expected.
+		Error: func(error) {},
+	}
+	a.Pkg, _ = conf.Check("main", fset, []*ast.File{f}, a.Info)
+	return a, nil
+}
+
+// Pos returns the position of a byte offset of the document.
+func (a *Analysis) Pos(offset int) token.Pos {
+	tf := a.Fset.File(a.File.Pos())
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > tf.Size() {
+		offset = tf.Size()
+	}
+	return tf.Pos(offset)
+}
+
+// Offset returns the byte offset of a position of the document.
+func (a *Analysis) Offset(pos token.Pos) int {
+	return a.Fset.Position(pos).Offset
+}
+
+// qualifier names packages other than the document's.
+func (a *Analysis) qualifier(p *types.Package) string {
+	if p == a.Pkg {
+		return ""
+	}
+	return p.Name()
+}
+
+// ByteOffset converts an offset into src counted in UTF-16 code units,
+// like the positions of JavaScript strings, to a byte offset.
+func ByteOffset(src []byte, units int) int {
+	i := 0
+	for i < len(src) && units > 0 {
+		r, size := utf8.DecodeRune(src[i:])
+		units -= len(utf16.Encode([]rune{r}))
+		i += size
+	}
+	return i
+}
+
+// UTF16Offset converts a byte offset into src to UTF-16 code units.
+func UTF16Offset(src []byte, offset int) int {
+	units := 0
+	for i := 0; i < len(src) && i < offset; {
+		r, size := utf8.DecodeRune(src[i:])
+		units += len(utf16.Encode([]rune{r}))
+		i += size
+	}
+	return units
+}
+
+// exportIndex is the export data files of the standard library of a
+// GOROOT, by import path. ready is closed once they are listed.
+type exportIndex struct {
+	ready chan struct{}
+	files map[string]string
+	err   error
+}
+
+var exports = struct {
+	sync.Mutex
+	roots map[string]*exportIndex
+}{roots: make(map[string]*exportIndex)}
+
+// WarmExports starts building the export data of the whole standard
+// library of tc into the cache of the go tool, once per GOROOT. It does
+// not wait: on a cold cache this takes longer than an editor request.
+func WarmExports(tc Toolchain) {
+	warmExports(tc)
+}
+
+func warmExports(tc Toolchain) *exportIndex {
+	root := tc.root()
+	exports.Lock()
+	defer exports.Unlock()
+	if idx := exports.roots[root]; idx != nil {
+		return idx
+	}
+	idx := &exportIndex{ready: make(chan struct{}), files: make(map[string]string)}
+	exports.roots[root] = idx
+
+	go func() {
+		defer close(idx.ready)
+		cmd := tc.Command(context.Background(), "list", "-export", "-f", "{{.ImportPath}} {{.Export}}", "std")
+		cmd.Dir = os.TempDir()
+		out, err := cmd.Output()
+		if err != nil {
+			idx.err = err
+			// Let the next request try again.
+			exports.Lock()
+			if exports.roots[root] == idx {
+				delete(exports.roots, root)
+			}
+			exports.Unlock()
+			return
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			if f := strings.Fields(line); len(f) == 2 {
+				idx.files[f[0]] = f[1]
+			}
+		}
+	}()
+	return idx
+}
+
+// exportLookup returns the export data of the standard library packages
+// of tc, waiting for WarmExports as long as ctx allows.
+func exportLookup(ctx context.Context, tc Toolchain) func(path string) (io.ReadCloser, error) {
+	return func(path string) (io.ReadCloser, error) {
+		idx := warmExports(tc)
+		select {
+		case <-idx.ready:
+		case <-ctx.Done():
+			return nil, errors.New("cannot import " + path + ": " + ctx.Err().Error())
+		}
+		if idx.err != nil {
+			return nil, errors.New("cannot import " + path)
+		}
+		file, ok := idx.files[path]
+		if !ok {
+			return nil, errors.New("no export data for " + path)
+		}
+		return os.Open(file)
+	}
+}
+
+var goroots = struct {
+	sync.Mutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+// root returns the GOROOT of the toolchain, asking the go tool of the
+// zero Toolchain.
+func (tc Toolchain) root() string {
+	if tc.GOROOT != "" {
+		return tc.GOROOT
+	}
+	goroots.Lock()
+	defer goroots.Unlock()
+	if root, ok := goroots.m[""]; ok {
+		return root
+	}
+	out, err := tc.Command(context.Background(), "env", "GOROOT").Output()
+	if err != nil {
+		return ""
+	}
+	goroots.m[""] = strings.TrimSpace(string(out))
+	return goroots.m[""]
+}
+
+// Doc returns the doc comment of obj, which is declared in the document
+// or the standard library.
+func (a *Analysis) Doc(obj types.Object) string {
+	if obj == nil {
+		return ""
+	}
+	if pkg, ok := obj.(*types.PkgName); ok {
+		return stdDocs(a.tc, pkg.Imported().Path())[""]
+	}
+	if !obj.Pos().IsValid() || obj.Pkg() == nil {
+		return ""
+	}
+	if obj.Pkg() == a.Pkg {
+		return a.declDoc(obj.Pos())
+	}
+	if key := docKey(obj); key != "" {
+		return stdDocs(a.tc, obj.Pkg().Path())[key]
+	}
+	return ""
+}
+
+// declDoc returns the doc comment of the declaration, spec or field of
+// the document declaring the name at pos.
+func (a *Analysis) declDoc(pos token.Pos) string {
+	path, _ := astutil.PathEnclosingInterval(a.File, pos, pos)
+	for _, n := range path {
+		switch n := n.(type) {
+		case *ast.Field:
+			return commentText(n.Doc, n.Comment)
+		case *ast.ValueSpec:
+			if text := commentText(n.Doc, n.Comment); text != "" {
+				return text
+			}
+		case *ast.TypeSpec:
+			if text := commentText(n.Doc, n.Comment); text != "" {
+				return text
+			}
+		case *ast.GenDecl:
+			// The doc of a group is not the doc of its specs.
+			if n.Lparen.IsValid() {
+				return ""
+			}
+			return commentText(n.Doc)
+		case *ast.FuncDecl:
+			if n.Name.Pos() != pos {
+				return ""
+			}
+			return commentText(n.Doc)
+		case *ast.DeclStmt:
+		case ast.Stmt:
+			// Names declared by statements, like x := 1, have no doc.
+			return ""
+		}
+	}
+	return ""
+}
+
+// commentText returns the text of the first of groups that is set.
+func commentText(groups ...*ast.CommentGroup) string {
+	for _, g := range groups {
+		if g != nil {
+			return g.Text()
+		}
+	}
+	return ""
+}
+
+// synopsis returns the first sentence of a doc comment.
+func synopsis(text string) string {
+	return new(doc.Package).Synopsis(text)
+}
@@ -0,0 +1,21 @@
+package lib
+
+import (
+	"context"
+	"testing"
+)
+
+func TestAnalyzeNoPackage(t *testing.T) {
+	ctx := context.Background()
+	for _, src := range []string{"", "pakage main", "// hi\n"} {
+		if _, err := Complete(ctx, Toolchain{}, []byte(src), 0); err == nil {
+			t.Errorf("Complete(%q): got no error", src)
+		}
+		if _, err := Hover(ctx, Toolchain{}, []byte(src), 0); err == nil {
+			t.Errorf("Hover(%q): got no error", src)
+		}
+		if _, err := Definition(ctx, Toolchain{}, []byte(src), 0); err == nil {
+			t.Errorf("Definition(%q): got no error", src)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package lib
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"time"
+)
+
+const (
+	DefaultAutoRunDelay = time.Second
+	MinAutoRunDelay     = 300 * time.Millisecond
+	MaxAutoRunDelay     = 10 * time.Second
+	// MinAutoRunInterval is the least time between the starts of two
+	// automatic runs of a room, however fast it is edited.
+	MinAutoRunInterval = 2 * time.Second
+)
+
+// AutoRun are the settings of a room for running the document
+// automatically once edits pause for Delay milliseconds. Config names
+// the run configuration, Live runs in live mode.
+type AutoRun struct {
+	Enabled bool
+	Delay   int
+	Config  string
+	Live    bool
+}
+
+// Validate checks the delay of enabled settings, disabled ones are kept
+// as they are.
+func (a AutoRun) Validate() error {
+	if !a.Enabled {
+		return nil
+	}
+	d := time.Duration(a.Delay) * time.Millisecond
+	if d < MinAutoRunDelay || d > MaxAutoRunDelay {
+		return fmt.Errorf("auto-run delay must be between %v and %v", MinAutoRunDelay, MaxAutoRunDelay)
+	}
+	return nil
+}
+
+// autoRunner debounces the edits of a room.
+type autoRunner struct {
+	timer *time.Timer
+	src   []byte
+	run   func(src []byte)
+	last  time.Time
+}
+
+// AutoRun returns the auto-run settings of the room.
+func (r *Room) AutoRun() AutoRun {
+	r.Lock()
+	defer r.Unlock()
+	return r.autoRun
+}
+
+func (r *Room) SetAutoRun(a AutoRun) error {
+	if err := a.Validate(); err != nil {
+		return err
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.autoRun = a
+	if !a.Enabled && r.auto.timer != nil {
+		r.auto.timer.Stop()
+	}
+	return nil
+}
+
+// Edited tells the room the document changed to src. With auto-run
+// enabled, run is called with the document once it hasn't changed for
+// the delay of the room and parses. run is expected to stop the run
+// from before right away, whether it is queued, building or running.
+func (r *Room) Edited(src []byte, run func(src []byte)) {
+	r.Lock()
+	defer r.Unlock()
+	if !r.autoRun.Enabled {
+		return
+	}
+	r.auto.src = src
+	r.auto.run = run
+	if r.auto.timer != nil {
+		r.auto.timer.Stop()
+	}
+	r.auto.timer = time.AfterFunc(time.Duration(r.autoRun.Delay)*time.Millisecond, r.fireAutoRun)
+}
+
+func (r *Room) fireAutoRun() {
+	r.Lock()
+	if !r.autoRun.Enabled {
+		r.Unlock()
+		return
+	}
+	if wait := MinAutoRunInterval - time.Since(r.auto.last); wait > 0 {
+		r.auto.timer = time.AfterFunc(wait, r.fireAutoRun)
+		r.Unlock()
+		return
+	}
+	src, run := r.auto.src, r.auto.run
+	r.Unlock()
+
+	if _, err := parser.ParseFile(token.NewFileSet(), progFile, src, 0); err != nil {
+		// Wait for the document to be complete.
+		return
+	}
+
+	r.Lock()
+	r.auto.last = time.Now()
+	r.Unlock()
+	run(src)
+}
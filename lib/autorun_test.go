@@ -0,0 +1,45 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAutoRun(t *testing.T) {
+	r, err := NewRoom("autorun", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetAutoRun(AutoRun{Enabled: true, Delay: 10}); err == nil {
+		t.Errorf("got no error for a 10ms delay")
+	}
+	if err := r.SetAutoRun(AutoRun{Delay: 0}); err != nil {
+		t.Errorf("disabling with no delay: %v", err)
+	}
+	if err := r.SetAutoRun(AutoRun{Enabled: true, Delay: 300}); err != nil {
+		t.Fatal(err)
+	}
+
+	runs := make(chan string, 10)
+	run := func(src []byte) { runs <- string(src) }
+
+	// Incomplete documents are not run.
+	r.Edited([]byte("package main\n\nfunc main() {"), run)
+	time.Sleep(500 * time.Millisecond)
+	select {
+	case src := <-runs:
+		t.Errorf("got run of %q", src)
+	default:
+	}
+
+	r.Edited([]byte("package main\n\nfunc main() {\n\tprintln()"), run)
+	r.Edited([]byte("package main\n\nfunc main() {}"), run)
+	select {
+	case src := <-runs:
+		if src != "package main\n\nfunc main() {}" {
+			t.Errorf("got run of %q", src)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no run")
+	}
+}
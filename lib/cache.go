@@ -0,0 +1,165 @@
+package lib
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Cache keeps the results of deterministic jobs, like formatting or
+// compiling the same source, by a hash of their input. Identical jobs
+// running at the same time share their result. The least
+// recently used results are dropped once the cache holds more than
+// MaxBytes, and results expire after TTL.
+type Cache struct {
+	MaxBytes int64
+	TTL      time.Duration
+
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	lru      *list.List // of *cacheEntry, most recently used first
+	size     int64
+	inflight map[string]*cacheCall
+}
+
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	size    int64
+	expires time.Time
+}
+
+// cacheCall is a job whose result is being computed, identical jobs
+// wait for it.
+type cacheCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+	// cancelled is set unless the job computing the result returned
+	// before being cancelled, the waiting ones compute it again.
+	cancelled bool
+}
+
+func NewCache(maxBytes int64, ttl time.Duration) *Cache {
+	return &Cache{
+		MaxBytes: maxBytes,
+		TTL:      ttl,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		inflight: make(map[string]*cacheCall),
+	}
+}
+
+// CacheKey hashes the input of a job: what it does, the toolchain, the
+// source and the options it is run with.
+func CacheKey(kind string, tc Toolchain, src []byte, opts ...interface{}) string {
+	h := sha256.New()
+	for _, s := range []string{kind, tc.Version, tc.GOROOT} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	h.Write(src)
+	h.Write([]byte{0})
+	json.NewEncoder(h).Encode(opts)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Do returns the cached result for key or calls fn to compute it, fn
+// runs under ctx. Calls for a key already being computed wait for that
+// result, or until their own ctx is done. Errors are not cached, and
+// neither is what fn returns once ctx is done or if it panics: the
+// calls waiting for it compute the result again. The results are
+// shared and must not be modified.
+func (c *Cache) Do(ctx context.Context, key string, fn func() (interface{}, error)) (interface{}, error) {
+	return c.do(ctx, key, true, fn)
+}
+
+// Share is like Do for jobs whose results may differ every time, like
+// runs: identical calls at the same time share a result, which is not
+// kept.
+func (c *Cache) Share(ctx context.Context, key string, fn func() (interface{}, error)) (interface{}, error) {
+	return c.do(ctx, key, false, fn)
+}
+
+func (c *Cache) do(ctx context.Context, key string, keep bool, fn func() (interface{}, error)) (interface{}, error) {
+	for {
+		c.mu.Lock()
+		if e, ok := c.entries[key]; ok && keep {
+			entry := e.Value.(*cacheEntry)
+			if time.Now().Before(entry.expires) {
+				c.lru.MoveToFront(e)
+				c.mu.Unlock()
+				return entry.value, nil
+			}
+			c.removeElement(e)
+		}
+		if call, ok := c.inflight[key]; ok {
+			c.mu.Unlock()
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			if call.cancelled {
+				continue
+			}
+			return call.value, call.err
+		}
+		call := &cacheCall{done: make(chan struct{}), cancelled: true}
+		c.inflight[key] = call
+		c.mu.Unlock()
+
+		// The waiting calls are released even if fn panics.
+		defer func() {
+			c.mu.Lock()
+			delete(c.inflight, key)
+			if keep && call.err == nil && !call.cancelled {
+				c.add(key, call.value)
+			}
+			c.mu.Unlock()
+			close(call.done)
+		}()
+		call.value, call.err = fn()
+		call.cancelled = ctx.Err() != nil
+		return call.value, call.err
+	}
+}
+
+// Len returns the number of results and their size.
+func (c *Cache) Len() (int, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len(), c.size
+}
+
+// add stores value, the size of a result is the size of its JSON
+// encoding, as that is how it is sent.
+func (c *Cache) add(key string, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil || int64(len(data)) > c.MaxBytes {
+		return
+	}
+	entry := &cacheEntry{
+		key:     key,
+		value:   value,
+		size:    int64(len(data)),
+		expires: time.Now().Add(c.TTL),
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.size += entry.size
+
+	for c.size > c.MaxBytes {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+func (c *Cache) removeElement(e *list.Element) {
+	entry := e.Value.(*cacheEntry)
+	c.lru.Remove(e)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
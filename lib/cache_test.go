@@ -0,0 +1,222 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	c := NewCache(20, time.Hour)
+
+	calls := 0
+	get := func(key, value string) string {
+		v, err := c.Do(context.Background(), key, func() (interface{}, error) {
+			calls++
+			return value, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v.(string)
+	}
+
+	if v := get("a", "aaaa"); v != "aaaa" || calls != 1 {
+		t.Fatalf("got %q after %d calls", v, calls)
+	}
+	if v := get("a", "other"); v != "aaaa" || calls != 1 {
+		t.Errorf("cached result not used, got %q after %d calls", v, calls)
+	}
+
+	// "aaaa" and "bbbb" take 6 bytes each as JSON, "cccccccccc" 12.
+	get("b", "bbbb")
+	get("a", "aaaa")
+	get("c", "cccccccccc")
+	if n, size := c.Len(); n != 2 || size != 18 {
+		t.Errorf("got %d results of %d bytes, want 2 of 18", n, size)
+	}
+	calls = 0
+	get("a", "aaaa")
+	get("b", "bbbb")
+	if calls != 1 {
+		t.Errorf("least recently used result not dropped, %d calls", calls)
+	}
+
+	if _, err := c.Do(context.Background(), "err", func() (interface{}, error) { return nil, errors.New("failed") }); err == nil {
+		t.Error("error not returned")
+	}
+	calls = 0
+	get("err", "ok")
+	if calls != 1 {
+		t.Error("error cached")
+	}
+
+	c.TTL = 0
+	get("d", "d")
+	calls = 0
+	get("d", "d")
+	if calls != 1 {
+		t.Error("expired result used")
+	}
+}
+
+func TestCacheInFlight(t *testing.T) {
+	c := NewCache(1<<10, time.Hour)
+
+	var mu sync.Mutex
+	calls := 0
+	release := make(chan bool)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, _ := c.Do(context.Background(), "key", func() (interface{}, error) {
+				mu.Lock()
+				calls++
+				mu.Unlock()
+				<-release
+				return 42, nil
+			})
+			if v != 42 {
+				t.Errorf("got %v", v)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("identical jobs ran %d times", calls)
+	}
+}
+
+func TestCacheCancelled(t *testing.T) {
+	c := NewCache(1<<10, time.Hour)
+
+	// The first job is cancelled while computing the result, the job
+	// waiting for it computes it again.
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan bool)
+	first := make(chan error)
+	go func() {
+		_, err := c.Do(ctx, "key", func() (interface{}, error) {
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+		first <- err
+	}()
+	<-started
+
+	second := make(chan interface{})
+	go func() {
+		v, _ := c.Do(context.Background(), "key", func() (interface{}, error) {
+			return 42, nil
+		})
+		second <- v
+	}()
+
+	// A job cancelled while waiting returns at once.
+	waiting, stop := context.WithCancel(context.Background())
+	stop()
+	if _, err := c.Do(waiting, "key", func() (interface{}, error) { return 0, nil }); err != context.Canceled {
+		t.Errorf("cancelled waiter got %v", err)
+	}
+
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Errorf("cancelled job got %v", err)
+	}
+	if v := <-second; v != 42 {
+		t.Errorf("waiting job got %v, want its own result", v)
+	}
+
+	// Results of cancelled jobs are not kept, even without an error.
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	c.Do(ctx, "partial", func() (interface{}, error) { return "partial", nil })
+	v, _ := c.Do(context.Background(), "partial", func() (interface{}, error) { return "full", nil })
+	if v != "full" {
+		t.Errorf("result of a cancelled job cached: %v", v)
+	}
+}
+
+func TestCacheShare(t *testing.T) {
+	c := NewCache(1<<10, time.Hour)
+
+	started := make(chan bool)
+	release := make(chan bool)
+	first := make(chan interface{})
+	go func() {
+		v, _ := c.Share(context.Background(), "key", func() (interface{}, error) {
+			close(started)
+			<-release
+			return 1, nil
+		})
+		first <- v
+	}()
+	<-started
+
+	// A call at the same time shares the result.
+	second := make(chan interface{})
+	go func() {
+		v, _ := c.Share(context.Background(), "key", func() (interface{}, error) { return 2, nil })
+		second <- v
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	if v1, v2 := <-first, <-second; v1 != 1 || v2 != 1 {
+		t.Errorf("got %v and %v, want the shared 1", v1, v2)
+	}
+
+	// A later one computes its own.
+	if v, _ := c.Share(context.Background(), "key", func() (interface{}, error) { return 3, nil }); v != 3 {
+		t.Errorf("got %v, the result was kept", v)
+	}
+	if n, _ := c.Len(); n != 0 {
+		t.Errorf("got %d cached results", n)
+	}
+}
+
+func TestCachePanic(t *testing.T) {
+	c := NewCache(1<<10, time.Hour)
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("panic not passed on")
+			}
+		}()
+		c.Do(context.Background(), "key", func() (interface{}, error) { panic("boom") })
+	}()
+
+	// The key is free again.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if v, err := c.Do(ctx, "key", func() (interface{}, error) { return 42, nil }); v != 42 {
+		t.Errorf("got %v, %v after a panic", v, err)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	tc := Toolchain{Version: "go1.22", GOROOT: "/go"}
+	key := CacheKey("asm", tc, []byte("src"))
+	for _, other := range []string{
+		CacheKey("opt", tc, []byte("src")),
+		CacheKey("asm", Toolchain{Version: "go1.21", GOROOT: "/go"}, []byte("src")),
+		CacheKey("asm", tc, []byte("src2")),
+		CacheKey("asm", tc, []byte("src"), "option"),
+	} {
+		if other == key {
+			t.Error("different jobs have the same key")
+		}
+	}
+	if CacheKey("asm", tc, []byte("src")) != key {
+		t.Error("key changed")
+	}
+}
@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// Clients are the connected clients, safe for use by the goroutines of
+// their connections.
+type Clients struct {
+	mu      sync.Mutex
+	clients map[*websocket.Conn]Client
+	next    int
+}
+
+func NewClients() *Clients {
+	return &Clients{clients: make(map[*websocket.Conn]Client)}
+}
+
+// Add registers the client of ws under a new id, named prefix followed
+// by the id.
+func (c *Clients) Add(ws *websocket.Conn, prefix string) Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := strconv.Itoa(c.next)
+	if c.next < 10 {
+		id = "0" + id
+	}
+	c.next++
+	client := Client{Id: id, Name: prefix + id, Conn: ws}
+	c.clients[ws] = client
+	return client
+}
+
+// Remove removes the client of ws and returns it.
+func (c *Clients) Remove(ws *websocket.Conn) (Client, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	client, ok := c.clients[ws]
+	delete(c.clients, ws)
+	return client, ok
+}
+
+func (c *Clients) Get(ws *websocket.Conn) (Client, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	client, ok := c.clients[ws]
+	return client, ok
+}
+
+// List returns the clients in the order they joined.
+func (c *Clients) List() []Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := make([]Client, 0, len(c.clients))
+	for _, client := range c.clients {
+		list = append(list, client)
+	}
+	sort.Sort(byClientId(list))
+	return list
+}
+
+func (c *Clients) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.clients)
+}
+
+type byClientId []Client
+
+func (s byClientId) Len() int      { return len(s) }
+func (s byClientId) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byClientId) Less(i, j int) bool {
+	if len(s[i].Id) != len(s[j].Id) {
+		return len(s[i].Id) < len(s[j].Id)
+	}
+	return s[i].Id < s[j].Id
+}
@@ -0,0 +1,25 @@
+package lib
+
+import (
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestClients(t *testing.T) {
+	c := NewClients()
+	a, b := new(websocket.Conn), new(websocket.Conn)
+
+	c.Add(a, "U-")
+	c.Add(b, "U-")
+	c.Remove(a)
+	// Ids are not reused once clients leave.
+	if got := c.Add(a, "U-"); got.Id != "02" || got.Name != "U-02" {
+		t.Errorf("got %+v", got)
+	}
+
+	list := c.List()
+	if len(list) != 2 || list[0].Conn != b || list[1].Conn != a {
+		t.Errorf("got %v", list)
+	}
+}
@@ -0,0 +1,51 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const compareRunTime = 10 * time.Second
+
+// CompareResult is the output of the document built with one toolchain.
+// Diff is the difference to the output of the first toolchain compared.
+type CompareResult struct {
+	Version string
+	Output  string
+	Status  string
+	Diff    string
+	Stats   RunStats
+}
+
+// CompareTimeout is the time comparing the output of n toolchains may
+// take, as they run one after the other.
+func CompareTimeout(n int) time.Duration {
+	return time.Duration(n)*compareRunTime + time.Minute
+}
+
+// Compare runs src with every toolchain of tcs and compares the outputs.
+func Compare(ctx context.Context, tcs []Toolchain, src []byte, cfg RunConfig) []CompareResult {
+	var results []CompareResult
+	for _, tc := range tcs {
+		r := CompareResult{Version: tc.Version, Status: "ok"}
+
+		out, stats, err := RunOutput(ctx, tc, src, cfg, compareRunTime)
+		r.Output = string(out)
+		r.Stats = stats
+		var build *BuildError
+		switch {
+		case errors.As(err, &build):
+			r.Status = "build failed"
+			r.Output = build.Output
+		case err != nil:
+			r.Status = err.Error()
+		}
+
+		if len(results) > 0 && r.Output != results[0].Output {
+			r.Diff = DiffText(results[0].Output, r.Output)
+		}
+		results = append(results, r)
+	}
+	return results
+}
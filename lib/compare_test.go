@@ -0,0 +1,24 @@
+package lib
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCompareStatus(t *testing.T) {
+	tcs := []Toolchain{{}}
+
+	// A program failing without output still built.
+	src := []byte("package main\n\nimport \"os\"\n\nfunc main() { os.Exit(3) }\n")
+	r := Compare(context.Background(), tcs, src, RunConfig{})
+	if r[0].Status != "exit status 3" || r[0].Output != "" {
+		t.Errorf("silent failure: status %q, output %q", r[0].Status, r[0].Output)
+	}
+
+	src = []byte("package main\n\nfunc main() { undefined() }\n")
+	r = Compare(context.Background(), tcs, src, RunConfig{})
+	if r[0].Status != "build failed" || !strings.Contains(r[0].Output, "undefined") {
+		t.Errorf("build failure: status %q, output %q", r[0].Status, r[0].Output)
+	}
+}
@@ -1,10 +1,13 @@
 package lib
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
 )
 
 type CompileResponse struct {
@@ -34,13 +37,17 @@ func ParseCompileResponse(data []byte) (CompileResponse, error) {
 	return cr, nil
 }
 
-func Compile(body string) ([]byte, error) {
+func Compile(ctx context.Context, body string) ([]byte, error) {
 
-	resp, err := http.PostForm("http://golang.org/compile",
-		url.Values{"version": {"2"}, "body": {body}})
-
-	resp.Header.Add("User-Agent", "Gopher-Gala-2015@julienc")
+	req, err := http.NewRequestWithContext(ctx, "POST", "http://golang.org/compile",
+		strings.NewReader(url.Values{"version": {"2"}, "body": {body}}.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "Gopher-Gala-2015@julienc")
 
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -49,5 +56,8 @@ func Compile(body string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("playground: %s", resp.Status)
+	}
 	return data, nil
 }
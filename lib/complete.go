@@ -0,0 +1,387 @@
+package lib
+
+import (
+	"context"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxCandidates is the number of completions returned at most.
+const maxCandidates = 200
+
+// Candidate is a completion at a position of the document. Kind is
+// "var", "const", "func", "method", "type", "field", "package" or
+// "builtin", Type the type or signature and Doc the first sentence of
+// the doc comment.
+type Candidate struct {
+	Name string
+	Kind string
+	Type string `json:",omitempty"`
+	Doc  string `json:",omitempty"`
+
+	// obj is what the candidate names, its doc is only looked up for
+	// the candidates returned.
+	obj types.Object
+}
+
+// importLineRe matches a line up to an import path being typed.
+var importLineRe = regexp.MustCompile(`^\s*(?:import\s*\(?\s*)?(?:[\w.]+\s+)?"([\w./-]*)$`)
+
+// Complete returns the completions at a byte offset of the document:
+// import paths, the fields and methods of a selector, the keys of a
+// struct literal or the names in scope.
+func Complete(ctx context.Context, tc Toolchain, src []byte, offset int) ([]Candidate, error) {
+	if offset < 0 || offset > len(src) {
+		offset = len(src)
+	}
+	a, err := Analyze(ctx, tc, src)
+	if err != nil {
+		return nil, err
+	}
+
+	lineStart := strings.LastIndex(string(src[:offset]), "\n") + 1
+	if m := importLineRe.FindSubmatch(src[lineStart:offset]); m != nil && a.inImports(offset) {
+		return completeImport(ctx, tc, string(m[1])), nil
+	}
+
+	// The identifier being typed, if any.
+	start := offset
+	for start > 0 && isIdentByte(src[start-1]) {
+		start--
+	}
+	prefix := string(src[start:offset])
+
+	var cands []Candidate
+	if start > 0 && src[start-1] == '.' {
+		cands = a.completeSelector(a.Pos(start - 1))
+	} else if fields, ok := a.completeKeys(a.Pos(start)); ok {
+		cands = fields
+	} else {
+		cands = a.completeScope(a.Pos(start))
+	}
+
+	var out []Candidate
+	for _, c := range cands {
+		if strings.HasPrefix(c.Name, prefix) && c.Name != "_" {
+			out = append(out, c)
+		}
+	}
+	sort.Sort(byCandidateName(out))
+	if len(out) > maxCandidates {
+		out = out[:maxCandidates]
+	}
+	for i := range out {
+		if out[i].obj != nil {
+			out[i].Doc = synopsis(a.Doc(out[i].obj))
+		}
+	}
+	return out, nil
+}
+
+func isIdentByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= 0x80
+}
+
+// inImports reports whether offset comes before the other declarations
+// of the document.
+func (a *Analysis) inImports(offset int) bool {
+	for _, d := range a.File.Decls {
+		if g, ok := d.(*ast.GenDecl); ok && g.Tok == token.IMPORT {
+			continue
+		}
+		return offset < a.Offset(d.Pos())
+	}
+	return true
+}
+
+// candidate describes obj as a completion.
+func (a *Analysis) candidate(obj types.Object) Candidate {
+	c := Candidate{Name: obj.Name(), obj: obj}
+	switch obj := obj.(type) {
+	case *types.Var:
+		c.Kind = "var"
+		if obj.IsField() {
+			c.Kind = "field"
+		}
+		c.Type = types.TypeString(obj.Type(), a.qualifier)
+	case *types.Const:
+		c.Kind = "const"
+		c.Type = types.TypeString(obj.Type(), a.qualifier)
+	case *types.Func:
+		c.Kind = "func"
+		if sig, ok := obj.Type().(*types.Signature); ok && sig.Recv() != nil {
+			c.Kind = "method"
+		}
+		c.Type = types.TypeString(obj.Type(), a.qualifier)
+	case *types.TypeName:
+		c.Kind = "type"
+		c.Type = typeKind(obj.Type())
+	case *types.PkgName:
+		c.Kind = "package"
+		c.Type = obj.Imported().Path()
+	case *types.Builtin:
+		c.Kind = "builtin"
+	case *types.Nil:
+		c.Kind = "builtin"
+	}
+	return c
+}
+
+// typeKind describes a named type by what it is underneath.
+func typeKind(t types.Type) string {
+	switch u := t.Underlying().(type) {
+	case *types.Struct:
+		return "struct"
+	case *types.Interface:
+		return "interface"
+	default:
+		return types.TypeString(u, func(p *types.Package) string { return p.Name() })
+	}
+}
+
+// completeSelector returns the members of the expression or package
+// before the dot at pos.
+func (a *Analysis) completeSelector(dot token.Pos) []Candidate {
+	var x ast.Expr
+	ast.Inspect(a.File, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok && sel.X.End() == dot {
+			x = sel.X
+		}
+		return x == nil
+	})
+	if x == nil {
+		return nil
+	}
+
+	var obj types.Object
+	switch e := x.(type) {
+	case *ast.Ident:
+		obj = a.Info.Uses[e]
+	case *ast.SelectorExpr:
+		// An incomplete selector on the line before makes the name a
+		// selector too: "p.\n\tfmt." parses as "p.fmt.".
+		if a.Fset.Position(e.X.End()).Line != a.Fset.Position(e.Sel.Pos()).Line {
+			x = e.Sel
+			if _, obj = a.innermost(dot).LookupParent(e.Sel.Name, dot); obj == nil {
+				return nil
+			}
+		}
+	}
+
+	var cands []Candidate
+	if pkg, ok := obj.(*types.PkgName); ok {
+		scope := pkg.Imported().Scope()
+		for _, name := range scope.Names() {
+			if obj := scope.Lookup(name); obj.Exported() {
+				cands = append(cands, a.candidate(obj))
+			}
+		}
+		return cands
+	}
+
+	t := a.Info.TypeOf(x)
+	if (t == nil || t == types.Typ[types.Invalid]) && obj != nil {
+		t = obj.Type()
+	}
+	if t == nil {
+		return nil
+	}
+	for _, obj := range members(t) {
+		if obj.Exported() || obj.Pkg() == a.Pkg {
+			cands = append(cands, a.candidate(obj))
+		}
+	}
+	return cands
+}
+
+// members returns the fields and methods of values of type t, promoted
+// ones included.
+func members(t types.Type) []types.Object {
+	var objs []types.Object
+	seen := make(map[string]bool)
+	add := func(obj types.Object) {
+		if !seen[obj.Name()] {
+			seen[obj.Name()] = true
+			objs = append(objs, obj)
+		}
+	}
+
+	// Methods of addressable values, which most selectors are.
+	mt := t
+	if _, ok := t.Underlying().(*types.Interface); !ok {
+		if _, ok := t.(*types.Pointer); !ok {
+			mt = types.NewPointer(t)
+		}
+	}
+	ms := types.NewMethodSet(mt)
+	for i := 0; i < ms.Len(); i++ {
+		add(ms.At(i).Obj())
+	}
+
+	// Fields, breadth first so that shallower ones win.
+	level := []types.Type{t}
+	visited := make(map[types.Type]bool)
+	for len(level) > 0 {
+		var next []types.Type
+		for _, t := range level {
+			if p, ok := t.Underlying().(*types.Pointer); ok {
+				t = p.Elem()
+			}
+			if visited[t] {
+				continue
+			}
+			visited[t] = true
+			s, ok := t.Underlying().(*types.Struct)
+			if !ok {
+				continue
+			}
+			for i := 0; i < s.NumFields(); i++ {
+				f := s.Field(i)
+				add(f)
+				if f.Embedded() {
+					next = append(next, f.Type())
+				}
+			}
+		}
+		level = next
+	}
+	return objs
+}
+
+// completeKeys returns the fields of the struct literal whose key is
+// at pos, leaving out the ones already set.
+func (a *Analysis) completeKeys(pos token.Pos) ([]Candidate, bool) {
+	var lit *ast.CompositeLit
+	ast.Inspect(a.File, func(n ast.Node) bool {
+		if n == nil || pos < n.Pos() || pos > n.End() {
+			return false
+		}
+		if cl, ok := n.(*ast.CompositeLit); ok && cl.Lbrace < pos && pos <= cl.Rbrace {
+			lit = cl
+			for _, e := range cl.Elts {
+				// Inside a value, not a key.
+				if kv, ok := e.(*ast.KeyValueExpr); ok && kv.Colon < pos && pos <= kv.End() {
+					lit = nil
+				}
+			}
+		}
+		return true
+	})
+	if lit == nil {
+		return nil, false
+	}
+	t := a.Info.TypeOf(lit)
+	if t == nil {
+		return nil, false
+	}
+	if p, ok := t.Underlying().(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	s, ok := t.Underlying().(*types.Struct)
+	if !ok {
+		return nil, false
+	}
+
+	set := make(map[string]bool)
+	for _, e := range lit.Elts {
+		if kv, ok := e.(*ast.KeyValueExpr); ok {
+			if id, ok := kv.Key.(*ast.Ident); ok {
+				set[id.Name] = true
+			}
+		}
+	}
+	var cands []Candidate
+	for i := 0; i < s.NumFields(); i++ {
+		f := s.Field(i)
+		if !set[f.Name()] && (f.Exported() || f.Pkg() == a.Pkg) {
+			cands = append(cands, a.candidate(f))
+		}
+	}
+	return cands, true
+}
+
+// completeScope returns the names in scope at pos, declared before it.
+func (a *Analysis) completeScope(pos token.Pos) []Candidate {
+	if a.Pkg == nil {
+		return nil
+	}
+	var cands []Candidate
+	seen := make(map[string]bool)
+	for s := a.innermost(pos); s != nil; s = s.Parent() {
+		for _, name := range s.Names() {
+			obj := s.Lookup(name)
+			if seen[name] {
+				continue
+			}
+			// Local names can only be used after their declaration.
+			if s != a.Pkg.Scope() && s != types.Universe && obj.Pos().IsValid() && obj.Pos() > pos {
+				continue
+			}
+			seen[name] = true
+			cands = append(cands, a.candidate(obj))
+		}
+	}
+	return cands
+}
+
+// innermost returns the innermost scope of the document containing pos.
+func (a *Analysis) innermost(pos token.Pos) *types.Scope {
+	s := a.Pkg.Scope()
+	if fs := a.Info.Scopes[a.File]; fs != nil {
+		s = fs
+	}
+	if inner := s.Innermost(pos); inner != nil {
+		return inner
+	}
+	return s
+}
+
+var stdPackages = struct {
+	sync.Mutex
+	m map[string][]string
+}{m: make(map[string][]string)}
+
+// completeImport returns the packages of the standard library starting
+// with prefix.
+func completeImport(ctx context.Context, tc Toolchain, prefix string) []Candidate {
+	root := tc.root()
+	stdPackages.Lock()
+	pkgs, ok := stdPackages.m[root]
+	stdPackages.Unlock()
+	if !ok {
+		cmd := tc.Command(ctx, "list", "std")
+		cmd.Dir = os.TempDir()
+		out, err := cmd.Output()
+		if err != nil {
+			return nil
+		}
+		for _, p := range strings.Fields(string(out)) {
+			if !strings.Contains(p, "internal") && !strings.HasPrefix(p, "vendor/") {
+				pkgs = append(pkgs, p)
+			}
+		}
+		stdPackages.Lock()
+		stdPackages.m[root] = pkgs
+		stdPackages.Unlock()
+	}
+
+	var cands []Candidate
+	for _, p := range pkgs {
+		if strings.HasPrefix(p, prefix) {
+			cands = append(cands, Candidate{Name: p, Kind: "package"})
+		}
+	}
+	return cands
+}
+
+type byCandidateName []Candidate
+
+func (s byCandidateName) Len() int           { return len(s) }
+func (s byCandidateName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byCandidateName) Less(i, j int) bool { return s[i].Name < s[j].Name }
@@ -0,0 +1,112 @@
+package lib
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestComplete(t *testing.T) {
+	src := `package main
+
+import (
+	"fmt"
+	"str
+)
+
+// Point is a point.
+type Point struct {
+	// X is the abscissa.
+	X, Y int
+	label string
+}
+
+func (p *Point) Move(dx int) { p.X += dx }
+
+func main() {
+	count := 1
+	p := Point{X: 1, }
+	p.
+	fmt.Print
+	cou
+	later := 2
+	_ = later
+}
+`
+	find := func(cands []Candidate, name string) *Candidate {
+		for i := range cands {
+			if cands[i].Name == name {
+				return &cands[i]
+			}
+		}
+		return nil
+	}
+	complete := func(marker string, after int) []Candidate {
+		i := strings.Index(src, marker)
+		if i < 0 {
+			t.Fatalf("no %q in source", marker)
+		}
+		cands, err := Complete(context.Background(), Toolchain{}, []byte(src), i+after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cands
+	}
+
+	cands := complete(`"str`, 4)
+	if find(cands, "strings") == nil || find(cands, "strconv") == nil || find(cands, "fmt") != nil {
+		t.Errorf("import paths: %v", cands)
+	}
+
+	cands = complete("p.\n", 2)
+	if c := find(cands, "X"); c == nil || c.Kind != "field" || c.Type != "int" || c.Doc != "X is the abscissa." {
+		t.Errorf("field X: %+v", c)
+	}
+	if c := find(cands, "Move"); c == nil || c.Kind != "method" || c.Type != "func(dx int)" {
+		t.Errorf("method Move: %+v", c)
+	}
+	if find(cands, "label") == nil {
+		t.Errorf("unexported field of the document missing: %v", cands)
+	}
+
+	cands = complete("fmt.Print", 9)
+	if c := find(cands, "Println"); c == nil || c.Kind != "func" || !strings.HasPrefix(c.Doc, "Println formats") {
+		t.Errorf("fmt.Println: %+v", c)
+	}
+	if find(cands, "Sprint") != nil {
+		t.Errorf("candidates not filtered by prefix: %v", cands)
+	}
+
+	cands = complete("X: 1, ", 6)
+	if find(cands, "X") != nil || find(cands, "Y") == nil {
+		t.Errorf("struct keys: %v", cands)
+	}
+
+	cands = complete("cou\n", 3)
+	if c := find(cands, "count"); c == nil || c.Kind != "var" || c.Type != "int" {
+		t.Errorf("local count: %+v", c)
+	}
+
+	cands = complete("cou\n", 0)
+	if find(cands, "later") != nil {
+		t.Errorf("name declared after the cursor offered: %v", cands)
+	}
+	if find(cands, "Point") == nil || find(cands, "len") == nil || find(cands, "fmt") == nil {
+		t.Errorf("package, universe or import names missing: %v", cands)
+	}
+}
+
+func TestByteOffset(t *testing.T) {
+	src := []byte("é😀x")
+	// é is 1 unit and 2 bytes, 😀 2 units and 4 bytes.
+	for _, c := range []struct{ units, want int }{{0, 0}, {1, 2}, {3, 6}, {4, 7}, {9, 7}} {
+		if got := ByteOffset(src, c.units); got != c.want {
+			t.Errorf("ByteOffset(%d) = %d, want %d", c.units, got, c.want)
+		}
+		if c.units <= 4 {
+			if got := UTF16Offset(src, c.want); got != c.units {
+				t.Errorf("UTF16Offset(%d) = %d, want %d", c.want, got, c.units)
+			}
+		}
+	}
+}
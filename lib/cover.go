@@ -0,0 +1,145 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+const coverProfile = "cover.out"
+
+type CoverRange struct {
+	StartLine int
+	StartCol  int
+	EndLine   int
+	EndCol    int
+	Count     int
+}
+
+// Coverage is the per-range coverage of the shared document.
+type Coverage struct {
+	Mode            string
+	Covered         []CoverRange
+	Uncovered       []CoverRange
+	NotInstrumented []CoverRange
+}
+
+// CoverTest runs the tests of src with -coverprofile and returns the
+// test output together with the coverage mapped onto src. Failing tests
+// are not an error, their output is returned as usual.
+func CoverTest(ctx context.Context, tc Toolchain, src []byte) ([]byte, *Coverage, error) {
+	p, err := NewProgram(tc, src)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer p.Remove()
+
+	out, _ := p.Go(ctx, "test", "-covermode=count", "-coverprofile="+coverProfile, "-timeout=10s").CombinedOutput()
+
+	data, err := ioutil.ReadFile(p.Path(coverProfile))
+	if err != nil {
+		// The build failed or there was nothing to test.
+		return out, nil, nil
+	}
+
+	cov, err := ParseCoverProfile(data, progModule+"/"+progFile, src)
+	if err != nil {
+		return out, nil, err
+	}
+	return out, cov, nil
+}
+
+// ParseCoverProfile parses the blocks of file in a cover profile. src is
+// the file content, parts of it not covered by any block are reported
+// as not instrumented.
+func ParseCoverProfile(data []byte, file string, src []byte) (*Coverage, error) {
+	cov := &Coverage{}
+	counts := make(map[CoverRange]int)
+
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := s.Text()
+		if strings.HasPrefix(line, "mode: ") {
+			cov.Mode = strings.TrimPrefix(line, "mode: ")
+			continue
+		}
+		if line == "" {
+			continue
+		}
+
+		// name.go:line.column,line.column numberOfStatements count
+		i := strings.LastIndex(line, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("bad cover profile line: %q", line)
+		}
+		if line[:i] != file {
+			continue
+		}
+
+		var r CoverRange
+		var stmts, count int
+		_, err := fmt.Sscanf(line[i+1:], "%d.%d,%d.%d %d %d",
+			&r.StartLine, &r.StartCol, &r.EndLine, &r.EndCol, &stmts, &count)
+		if err != nil {
+			return nil, fmt.Errorf("bad cover profile line: %q", line)
+		}
+		counts[r] += count
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	blocks := make([]CoverRange, 0, len(counts))
+	for r, c := range counts {
+		r.Count = c
+		blocks = append(blocks, r)
+	}
+	sort.Sort(byStart(blocks))
+
+	for _, b := range blocks {
+		if b.Count > 0 {
+			cov.Covered = append(cov.Covered, b)
+		} else {
+			cov.Uncovered = append(cov.Uncovered, b)
+		}
+	}
+
+	cov.NotInstrumented = coverGaps(blocks, src)
+	return cov, nil
+}
+
+// coverGaps returns the parts of src between the sorted blocks.
+func coverGaps(blocks []CoverRange, src []byte) []CoverRange {
+	lines := bytes.Split(src, []byte("\n"))
+	endLine, endCol := len(lines), len(lines[len(lines)-1])+1
+
+	var gaps []CoverRange
+	line, col := 1, 1
+	for _, b := range blocks {
+		if b.StartLine > line || b.StartLine == line && b.StartCol > col {
+			gaps = append(gaps, CoverRange{StartLine: line, StartCol: col, EndLine: b.StartLine, EndCol: b.StartCol})
+		}
+		if b.EndLine > line || b.EndLine == line && b.EndCol > col {
+			line, col = b.EndLine, b.EndCol
+		}
+	}
+	if endLine > line || endLine == line && endCol > col {
+		gaps = append(gaps, CoverRange{StartLine: line, StartCol: col, EndLine: endLine, EndCol: endCol})
+	}
+	return gaps
+}
+
+type byStart []CoverRange
+
+func (s byStart) Len() int      { return len(s) }
+func (s byStart) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byStart) Less(i, j int) bool {
+	if s[i].StartLine != s[j].StartLine {
+		return s[i].StartLine < s[j].StartLine
+	}
+	return s[i].StartCol < s[j].StartCol
+}
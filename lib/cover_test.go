@@ -0,0 +1,40 @@
+package lib
+
+import "testing"
+
+func TestParseCoverProfile(t *testing.T) {
+	src := []byte("package main\n\nfunc a() {\n\tprintln()\n}\n\nfunc b() {\n\tprintln()\n}\n")
+	data := []byte("mode: count\n" +
+		"prog/prog.go:3.10,5.2 1 2\n" +
+		"prog/prog.go:7.10,9.2 1 0\n" +
+		"prog/other.go:1.1,2.2 1 1\n")
+
+	cov, err := ParseCoverProfile(data, "prog/prog.go", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cov.Mode != "count" {
+		t.Errorf("got %v want count", cov.Mode)
+	}
+	if len(cov.Covered) != 1 || cov.Covered[0].StartLine != 3 || cov.Covered[0].Count != 2 {
+		t.Errorf("got %v want one covered block at line 3", cov.Covered)
+	}
+	if len(cov.Uncovered) != 1 || cov.Uncovered[0].StartLine != 7 {
+		t.Errorf("got %v want one uncovered block at line 7", cov.Uncovered)
+	}
+
+	want := []CoverRange{
+		{StartLine: 1, StartCol: 1, EndLine: 3, EndCol: 10},
+		{StartLine: 5, StartCol: 2, EndLine: 7, EndCol: 10},
+		{StartLine: 9, StartCol: 2, EndLine: 10, EndCol: 1},
+	}
+	if len(cov.NotInstrumented) != len(want) {
+		t.Fatalf("got %v want %v", cov.NotInstrumented, want)
+	}
+	for i := range want {
+		if cov.NotInstrumented[i] != want[i] {
+			t.Errorf("got %v want %v", cov.NotInstrumented[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package lib
+
+import (
+	"bytes"
+	"strings"
+)
+
+// DiffLine is a line of a line-based diff. Kind is ' ' for lines found
+// in both inputs, '-' for lines only in a and '+' for lines only in b.
+// A and B are the 0-based line numbers in a and b, or -1.
+type DiffLine struct {
+	Kind byte
+	Text string
+	A    int
+	B    int
+}
+
+// maxDiffCells bounds the table Diff fills, the product of the numbers
+// of lines left once the common start and end are skipped.
+const maxDiffCells = 1 << 22
+
+// Diff returns the shortest edit script turning a into b. If the lines
+// that differ are too many to compare with each other, they are all
+// removed and then added instead.
+func Diff(a, b []string) []DiffLine {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	var d []DiffLine
+	for i := 0; i < pre; i++ {
+		d = append(d, DiffLine{Kind: ' ', Text: a[i], A: i, B: i})
+	}
+	d = append(d, diffLCS(a[pre:len(a)-suf], b[pre:len(b)-suf], pre)...)
+	for i := suf; i > 0; i-- {
+		d = append(d, DiffLine{Kind: ' ', Text: a[len(a)-i], A: len(a) - i, B: len(b) - i})
+	}
+	return d
+}
+
+// diffLCS diffs a and b, which start at line off, by their longest
+// common subsequence.
+func diffLCS(a, b []string, off int) []DiffLine {
+	var d []DiffLine
+	if len(a)*len(b) > maxDiffCells {
+		for i, l := range a {
+			d = append(d, DiffLine{Kind: '-', Text: l, A: off + i, B: -1})
+		}
+		for j, l := range b {
+			d = append(d, DiffLine{Kind: '+', Text: l, A: -1, B: off + j})
+		}
+		return d
+	}
+
+	// lcs(i, j) is the length of the longest common subsequence of
+	// a[i:] and b[j:].
+	w := len(b) + 1
+	table := make([]int32, (len(a)+1)*w)
+	lcs := func(i, j int) int32 { return table[i*w+j] }
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				table[i*w+j] = lcs(i+1, j+1) + 1
+			} else if lcs(i+1, j) >= lcs(i, j+1) {
+				table[i*w+j] = lcs(i+1, j)
+			} else {
+				table[i*w+j] = lcs(i, j+1)
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			d = append(d, DiffLine{Kind: ' ', Text: a[i], A: off + i, B: off + j})
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs(i+1, j) >= lcs(i, j+1):
+			d = append(d, DiffLine{Kind: '-', Text: a[i], A: off + i, B: -1})
+			i++
+		default:
+			d = append(d, DiffLine{Kind: '+', Text: b[j], A: -1, B: off + j})
+			j++
+		}
+	}
+	return d
+}
+
+// DiffText returns the diff of a and b in a unified-like format with
+// every line prefixed by its kind.
+func DiffText(a, b string) string {
+	var buf bytes.Buffer
+	for _, l := range Diff(SplitLines(a), SplitLines(b)) {
+		buf.WriteByte(l.Kind)
+		buf.WriteString(l.Text)
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// SplitLines splits s into lines, ignoring a trailing newline.
+func SplitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// LineEdit replaces Delete lines of a text, starting at the 0-based
+// Line, with Lines.
+type LineEdit struct {
+	Line   int
+	Delete int
+	Lines  []string
+}
+
+// LineEdits returns the edits turning a into b, in order. Lines are
+// split at every newline, so that a trailing newline is an empty last
+// line, like in editors.
+func LineEdits(a, b string) []LineEdit {
+	var edits []LineEdit
+	var e *LineEdit
+	line := 0
+	for _, d := range Diff(strings.Split(a, "\n"), strings.Split(b, "\n")) {
+		if d.Kind == ' ' {
+			e = nil
+			line++
+			continue
+		}
+		if e == nil {
+			edits = append(edits, LineEdit{Line: line})
+			e = &edits[len(edits)-1]
+		}
+		if d.Kind == '-' {
+			e.Delete++
+			line++
+		} else {
+			e.Lines = append(e.Lines, d.Text)
+		}
+	}
+	return edits
+}
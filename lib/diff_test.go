@@ -0,0 +1,80 @@
+package lib
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDiffText(t *testing.T) {
+	a := "a\nb\nc\n"
+	b := "a\nx\nc\nd\n"
+
+	got := DiffText(a, b)
+	want := " a\n-b\n+x\n c\n+d\n"
+	if got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
+
+func TestDiffLineNumbers(t *testing.T) {
+	d := Diff([]string{"a", "b"}, []string{"b"})
+	if len(d) != 2 {
+		t.Fatalf("got %v want 2 lines", d)
+	}
+	if d[0].Kind != '-' || d[0].A != 0 || d[0].B != -1 {
+		t.Errorf("got %+v want a removed from line 0", d[0])
+	}
+	if d[1].Kind != ' ' || d[1].A != 1 || d[1].B != 0 {
+		t.Errorf("got %+v want b kept from line 1 to 0", d[1])
+	}
+}
+
+func TestDiffLarge(t *testing.T) {
+	// Too many different lines to compare all, they are replaced.
+	var a, b []string
+	for i := 0; i < 3000; i++ {
+		a = append(a, "a"+strconv.Itoa(i))
+		b = append(b, "b"+strconv.Itoa(i))
+	}
+	a = append([]string{"same"}, append(a, "end")...)
+	b = append([]string{"same"}, append(b, "end")...)
+
+	d := Diff(a, b)
+	if len(d) != 6002 {
+		t.Fatalf("got %d lines want 6002", len(d))
+	}
+	if d[0].Kind != ' ' || d[1].Kind != '-' || d[1].A != 1 || d[3001].Kind != '+' || d[3001].B != 1 {
+		t.Errorf("got %+v, %+v and %+v", d[0], d[1], d[3001])
+	}
+	if last := d[len(d)-1]; last.Kind != ' ' || last.A != 3001 || last.B != 3001 {
+		t.Errorf("got %+v for the last line", last)
+	}
+}
+
+func TestLineEdits(t *testing.T) {
+	a := "package main\nfunc main() {\nx:=1\n\n}"
+	b := "package main\n\nfunc main() {\n\tx := 1\n\n}\n"
+
+	edits := LineEdits(a, b)
+	if len(edits) != 3 {
+		t.Errorf("got %+v, want 3 edits", edits)
+	}
+	if got := applyLineEdits(a, edits); got != b {
+		t.Errorf("got %q want %q", got, b)
+	}
+	if edits := LineEdits(b, b); len(edits) != 0 {
+		t.Errorf("got %+v for the same text", edits)
+	}
+}
+
+// applyLineEdits applies edits the way clients do.
+func applyLineEdits(a string, edits []LineEdit) string {
+	lines := strings.Split(a, "\n")
+	for i := len(edits) - 1; i >= 0; i-- {
+		e := edits[i]
+		rest := append(append([]string(nil), e.Lines...), lines[e.Line+e.Delete:]...)
+		lines = append(lines[:e.Line], rest...)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,244 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/doc"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ExampleResult is the outcome of an Example function with an output
+// comment.
+type ExampleResult struct {
+	Name   string
+	Passed bool
+	Want   string
+	Got    string
+	Diff   string
+}
+
+// exampleLine is a line of an output comment and its document line.
+type exampleLine struct {
+	Line int
+	Text string
+}
+
+var (
+	outputPrefixRe = regexp.MustCompile(`(?i)^[[:space:]]*(unordered )?output:`)
+	examplePassRe  = regexp.MustCompile(`^--- PASS: (Example\S*) `)
+	exampleFailRe  = regexp.MustCompile(`^--- FAIL: (Example\S*) `)
+)
+
+// RunExamples runs the examples of src that have an output comment and
+// compares their output with it. Mismatches are reported as diagnostics
+// on the lines of the output comment.
+func RunExamples(ctx context.Context, tc Toolchain, src []byte) ([]byte, []ExampleResult, []Diagnostic, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, progFile, src, parser.ParseComments)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var examples []*doc.Example
+	for _, ex := range doc.Examples(f) {
+		if ex.Output != "" || ex.EmptyOutput {
+			examples = append(examples, ex)
+		}
+	}
+	if len(examples) == 0 {
+		return nil, nil, nil, fmt.Errorf("no example with an output comment found")
+	}
+
+	names := make([]string, len(examples))
+	for i, ex := range examples {
+		names[i] = "Example" + ex.Name
+	}
+
+	p, err := NewProgram(tc, src)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer p.Remove()
+
+	// Example names don't have to refer to a declared identifier here,
+	// so the vet checks of go test are turned off.
+	out, _ := p.Go(ctx, "test", "-v", "-vet=off", "-timeout=10s", "-run=^("+strings.Join(names, "|")+")$").CombinedOutput()
+	passed, got := parseExampleOutput(out)
+
+	var results []ExampleResult
+	var diags []Diagnostic
+	for i, ex := range examples {
+		r := ExampleResult{Name: names[i], Want: ex.Output}
+		if passed[r.Name] {
+			r.Passed = true
+			r.Got = ex.Output
+		} else if g, ok := got[r.Name]; ok {
+			r.Got = g
+			r.Diff = DiffText(ex.Output, g)
+			diags = append(diags, exampleDiagnostics(fset, f, r.Name, ex.Unordered, g)...)
+		} else {
+			// The build failed or the example didn't complete.
+			continue
+		}
+		results = append(results, r)
+	}
+	return out, results, diags, nil
+}
+
+// parseExampleOutput returns the passed examples and the actual output
+// of every failed example in the output of go test -v.
+func parseExampleOutput(out []byte) (map[string]bool, map[string]string) {
+	passed := make(map[string]bool)
+	got := make(map[string]string)
+
+	var name string
+	var buf bytes.Buffer
+	reading := false
+
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		line := s.Text()
+		if m := examplePassRe.FindStringSubmatch(line); m != nil {
+			passed[m[1]] = true
+			continue
+		}
+
+		switch {
+		case name == "":
+			if m := exampleFailRe.FindStringSubmatch(line); m != nil {
+				name = m[1]
+			}
+		case !reading && line == "got:":
+			reading = true
+		case reading && (line == "want:" || line == "want (unordered):"):
+			// go test compares outputs without surrounding space and
+			// follows unordered output with an empty line.
+			got[name] = strings.TrimRight(buf.String(), "\n")
+			if got[name] != "" {
+				got[name] += "\n"
+			}
+			name, reading = "", false
+			buf.Reset()
+		case reading:
+			buf.WriteString(line + "\n")
+		}
+	}
+	return passed, got
+}
+
+func exampleDiagnostics(fset *token.FileSet, f *ast.File, name string, unordered bool, got string) []Diagnostic {
+	lines := exampleOutputLines(fset, f, name)
+	if len(lines) == 0 {
+		return nil
+	}
+
+	want := make([]string, len(lines))
+	for i, l := range lines {
+		want[i] = strings.TrimSpace(l.Text)
+	}
+	have := SplitLines(strings.TrimSpace(got))
+	for i := range have {
+		have[i] = strings.TrimSpace(have[i])
+	}
+	if unordered {
+		sort.Strings(have)
+		sorted := append([]string(nil), want...)
+		sort.Strings(sorted)
+		if strings.Join(sorted, "\n") != strings.Join(have, "\n") {
+			return []Diagnostic{{
+				Line:    lines[0].Line,
+				Col:     1,
+				Message: fmt.Sprintf("%s: got unordered output:\n%s", name, strings.Join(have, "\n")),
+			}}
+		}
+		return nil
+	}
+
+	var diags []Diagnostic
+	next := 0
+	for _, d := range Diff(want, have) {
+		switch d.Kind {
+		case ' ':
+			next = d.A + 1
+		case '-':
+			diags = append(diags, Diagnostic{
+				Line:    lines[d.A].Line,
+				Col:     1,
+				Message: fmt.Sprintf("%s: missing output %q", name, d.Text),
+			})
+			next = d.A + 1
+		case '+':
+			line := lines[len(lines)-1].Line
+			if next < len(lines) {
+				line = lines[next].Line
+			}
+			diags = append(diags, Diagnostic{
+				Line:    line,
+				Col:     1,
+				Message: fmt.Sprintf("%s: unexpected output %q", name, d.Text),
+			})
+		}
+	}
+	return diags
+}
+
+// exampleOutputLines returns the expected output lines in the output
+// comment of the named example. Like go/doc, the output comment is the
+// last comment of the function body.
+func exampleOutputLines(fset *token.FileSet, f *ast.File, name string) []exampleLine {
+	var body *ast.BlockStmt
+	for _, d := range f.Decls {
+		if fn, ok := d.(*ast.FuncDecl); ok && fn.Recv == nil && fn.Name.Name == name {
+			body = fn.Body
+		}
+	}
+	if body == nil {
+		return nil
+	}
+
+	var last *ast.CommentGroup
+	for _, cg := range f.Comments {
+		if cg.Pos() > body.Lbrace && cg.End() < body.Rbrace {
+			last = cg
+		}
+	}
+	if last == nil {
+		return nil
+	}
+
+	var lines []exampleLine
+	for _, c := range last.List {
+		line := fset.Position(c.Pos()).Line
+		if strings.HasPrefix(c.Text, "//") {
+			lines = append(lines, exampleLine{line, strings.TrimPrefix(c.Text[2:], " ")})
+			continue
+		}
+		for i, text := range strings.Split(c.Text[2:len(c.Text)-2], "\n") {
+			lines = append(lines, exampleLine{line + i, text})
+		}
+	}
+
+	for i, l := range lines {
+		loc := outputPrefixRe.FindStringIndex(l.Text)
+		if loc == nil {
+			continue
+		}
+		lines = lines[i:]
+		lines[0].Text = l.Text[loc[1]:]
+		if strings.TrimSpace(lines[0].Text) == "" {
+			lines = lines[1:]
+		}
+		break
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1].Text) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
@@ -0,0 +1,132 @@
+package lib
+
+import (
+	"context"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+const exampleSrc = `package main
+
+import "fmt"
+
+func main() {}
+
+func ExampleHello() {
+	fmt.Println("hello")
+	// Output: hello
+}
+
+func ExampleCount() {
+	fmt.Println(1)
+	fmt.Println(3)
+	// Output:
+	// 1
+	// 2
+}
+
+func ExampleSet() {
+	fmt.Println("b")
+	fmt.Println("a")
+	// Unordered output:
+	// a
+	// c
+}
+`
+
+// exampleTestOutput is what go test -v prints for exampleSrc.
+const exampleTestOutput = `=== RUN   ExampleHello
+--- PASS: ExampleHello (0.00s)
+=== RUN   ExampleCount
+--- FAIL: ExampleCount (0.00s)
+got:
+1
+3
+want:
+1
+2
+=== RUN   ExampleSet
+--- FAIL: ExampleSet (0.00s)
+got:
+b
+a
+
+want (unordered):
+a
+c
+
+FAIL
+exit status 1
+FAIL	prog	0.001s
+`
+
+func TestParseExampleOutput(t *testing.T) {
+	passed, got := parseExampleOutput([]byte(exampleTestOutput))
+	if len(passed) != 1 || !passed["ExampleHello"] {
+		t.Errorf("passed: %v", passed)
+	}
+	if len(got) != 2 || got["ExampleCount"] != "1\n3\n" || got["ExampleSet"] != "b\na\n" {
+		t.Errorf("got: %q", got)
+	}
+}
+
+func TestExampleDiagnostics(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, progFile, exampleSrc, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both differences are on the line of the missing "2".
+	diags := exampleDiagnostics(fset, f, "ExampleCount", false, "1\n3\n")
+	if len(diags) != 2 {
+		t.Fatalf("got %+v", diags)
+	}
+	for _, d := range diags {
+		if d.Line != 17 {
+			t.Errorf("%q on line %d, want 17", d.Message, d.Line)
+		}
+	}
+	if !strings.Contains(diags[0].Message, `missing output "2"`) || !strings.Contains(diags[1].Message, `unexpected output "3"`) {
+		t.Errorf("got %q and %q", diags[0].Message, diags[1].Message)
+	}
+
+	// Unordered output is reported once, on its first line.
+	diags = exampleDiagnostics(fset, f, "ExampleSet", true, "b\na\n")
+	if len(diags) != 1 || diags[0].Line != 24 {
+		t.Errorf("got %+v", diags)
+	}
+	if diags := exampleDiagnostics(fset, f, "ExampleSet", true, "c\na\n"); len(diags) != 0 {
+		t.Errorf("reordered output: got %+v", diags)
+	}
+}
+
+func TestRunExamples(t *testing.T) {
+	_, results, diags, err := RunExamples(context.Background(), Toolchain{}, []byte(exampleSrc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sorted by name, like go/doc does.
+	want := []ExampleResult{
+		{Name: "ExampleCount", Want: "1\n2\n", Got: "1\n3\n"},
+		{Name: "ExampleHello", Passed: true, Want: "hello\n", Got: "hello\n"},
+		{Name: "ExampleSet", Want: "a\nc\n", Got: "b\na\n"},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %+v", results)
+	}
+	for i, r := range results {
+		r.Diff = ""
+		if r != want[i] {
+			t.Errorf("got %+v, want %+v", r, want[i])
+		}
+	}
+	if results[0].Diff == "" {
+		t.Error("no diff for ExampleCount")
+	}
+	if len(diags) != 3 {
+		t.Errorf("got %d diagnostics, want 3: %+v", len(diags), diags)
+	}
+}
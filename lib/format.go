@@ -1,30 +1,372 @@
 package lib
 
 import (
-	"io/ioutil"
-	"os"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/scanner"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
 
 	"golang.org/x/tools/imports"
 )
 
-func Format(data []byte) ([]byte, error) {
+// FormatOptions are the settings of a room for formatting the document.
+type FormatOptions struct {
+	TabWidth  int
+	TabIndent bool
+	// Comments keeps the comments, formatting without them strips
+	// them.
+	Comments bool
+	// FormatOnly formats without adding or removing imports.
+	FormatOnly bool
+	// Simplify simplifies the code like gofmt -s.
+	Simplify bool
+	// LocalPrefixes are import path prefixes grouped after the other
+	// imports, like goimports -local.
+	LocalPrefixes []string `json:",omitempty"`
+	// Index resolves the imports the standard library and GOPATH
+	// don't have. It is set by the server.
+	Index *ImportIndex `json:"-"`
+}
+
+var DefaultFormatOptions = FormatOptions{
+	TabWidth:  8,
+	TabIndent: true,
+	Comments:  true,
+}
+
+func (o FormatOptions) Validate() error {
+	if o.TabWidth < 1 || o.TabWidth > 16 {
+		return fmt.Errorf("tab width must be between 1 and 16")
+	}
+	for _, p := range o.LocalPrefixes {
+		if p == "" || strings.ContainsAny(p, "\" \t") {
+			return fmt.Errorf("bad import path prefix %q", p)
+		}
+	}
+	return nil
+}
+
+// Format formats the document and fixes its imports in memory.
+func Format(src []byte, opts FormatOptions) ([]byte, error) {
+	if !opts.FormatOnly {
+		// imports.Process doesn't read files, the name only appears in
+		// errors.
+		out, err := imports.Process(progFile, src, &imports.Options{
+			Comments:  opts.Comments,
+			TabIndent: true,
+			TabWidth:  8,
+		})
+		if err != nil {
+			return nil, err
+		}
+		src = out
+	}
 
-	tmp, err := ioutil.TempFile("", "")
+	mode := parser.Mode(0)
+	if opts.Comments {
+		mode |= parser.ParseComments
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, progFile, src, mode)
 	if err != nil {
 		return nil, err
 	}
+	added := false
+	if opts.Index != nil && !opts.FormatOnly {
+		added = opts.Index.addImports(fset, f)
+	}
+	ast.SortImports(fset, f)
+
+	// Added imports are grouped like goimports would.
+	if len(opts.LocalPrefixes) > 0 || added {
+		var buf bytes.Buffer
+		if err := printer.Fprint(&buf, fset, f); err != nil {
+			return nil, err
+		}
+		fset = token.NewFileSet()
+		if f, err = parser.ParseFile(fset, progFile, groupImports(buf.Bytes(), opts.LocalPrefixes), mode); err != nil {
+			return nil, err
+		}
+	}
+	return printFile(fset, f, opts)
+}
 
-	filename := tmp.Name()
+// FormatRange formats the lines first to last of src, counted from 0,
+// as declarations or statements on their own. The rest of the document
+// is kept as is and doesn't need to parse, imports are not fixed.
+func FormatRange(src []byte, first, last int, opts FormatOptions) ([]byte, error) {
+	lines := strings.SplitAfter(string(src), "\n")
+	if first < 0 || first > last || last >= len(lines) {
+		return nil, fmt.Errorf("bad range of lines %d to %d", first+1, last+1)
+	}
+	sel := strings.Join(lines[first:last+1], "")
+	if strings.TrimSpace(sel) == "" {
+		return src, nil
+	}
 
-	err = ioutil.WriteFile(filename, data, os.ModePerm)
+	out, err := formatFragment(sel, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	out, err := imports.Process(filename, data, nil)
+	// The selection is indented by the depth of the block it is in,
+	// lines inside raw strings are kept as they are.
+	indent := strings.Repeat(indentUnit(opts), blockDepth(strings.Join(lines[:first], "")))
+	raw := rawStringLines(string(out))
+	outLines := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+	for i, l := range outLines {
+		if l != "" && !raw[i] {
+			outLines[i] = indent + l
+		}
+	}
+	formatted := strings.Join(outLines, "\n")
+	if strings.HasSuffix(sel, "\n") {
+		formatted += "\n"
+	}
+
+	return []byte(strings.Join(lines[:first], "") + formatted + strings.Join(lines[last+1:], "")), nil
+}
+
+// formatFragment formats src as a list of declarations, or else of
+// statements.
+func formatFragment(src string, opts FormatOptions) ([]byte, error) {
+	mode := parser.Mode(0)
+	if opts.Comments {
+		mode |= parser.ParseComments
+	}
+
+	fset := token.NewFileSet()
+	if f, err := parser.ParseFile(fset, progFile, "package p;"+src, mode); err == nil {
+		out, err := printFile(fset, f, opts)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimLeft(bytes.TrimPrefix(out, []byte("package p\n")), "\n"), nil
+	}
+
+	fset = token.NewFileSet()
+	f, err := parser.ParseFile(fset, progFile, "package p; func _() {\n"+src+"\n}", mode)
 	if err != nil {
 		return nil, err
 	}
+	out, err := printFile(fset, f, opts)
+	if err != nil {
+		return nil, err
+	}
+	body := string(out[bytes.Index(out, []byte("{\n"))+2 : bytes.LastIndex(out, []byte("}"))])
+
+	// The statements lose the indentation of the function body.
+	unit := indentUnit(opts)
+	raw := rawStringLines(body)
+	lines := strings.Split(body, "\n")
+	for i, l := range lines {
+		if !raw[i] {
+			lines[i] = strings.TrimPrefix(l, unit)
+		}
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// indentUnit returns what one level of indentation is printed as.
+func indentUnit(opts FormatOptions) string {
+	if !opts.TabIndent {
+		return strings.Repeat(" ", opts.TabWidth)
+	}
+	return "\t"
+}
+
+// blockDepth returns the indentation level of code following src, the
+// number of lines with brackets still open at its end. src doesn't need
+// to parse, a closing brace also closes what was left open in its block.
+func blockDepth(src string) int {
+	type bracket struct {
+		tok  token.Token
+		line int
+	}
+	var open []bracket
+	closing := func(tok token.Token) {
+		for len(open) > 0 {
+			b := open[len(open)-1]
+			if b.tok != tok && tok != token.LBRACE {
+				return
+			}
+			open = open[:len(open)-1]
+			if b.tok == tok {
+				return
+			}
+		}
+	}
+	scanTokens(src, func(pos token.Position, tok token.Token, lit string) {
+		switch tok {
+		case token.LBRACE, token.LPAREN, token.LBRACK:
+			open = append(open, bracket{tok, pos.Line})
+		case token.RBRACE:
+			closing(token.LBRACE)
+		case token.RPAREN:
+			closing(token.LPAREN)
+		case token.RBRACK:
+			closing(token.LBRACK)
+		}
+	})
+	// Brackets opened on the same line indent once.
+	lines := make(map[int]bool)
+	for _, b := range open {
+		lines[b.line] = true
+	}
+	return len(lines)
+}
+
+// rawStringLines returns the lines of src, counted from 0, that start
+// inside a raw string.
+func rawStringLines(src string) map[int]bool {
+	raw := make(map[int]bool)
+	scanTokens(src, func(pos token.Position, tok token.Token, lit string) {
+		if tok == token.STRING && strings.HasPrefix(lit, "`") {
+			for i := 1; i <= strings.Count(lit, "\n"); i++ {
+				raw[pos.Line-1+i] = true
+			}
+		}
+	})
+	return raw
+}
+
+// scanTokens passes the tokens of src to fn, ignoring errors.
+func scanTokens(src string, fn func(pos token.Position, tok token.Token, lit string)) {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, len(src))
+	var s scanner.Scanner
+	s.Init(file, []byte(src), func(token.Position, string) {}, 0)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			return
+		}
+		fn(fset.Position(pos), tok, lit)
+	}
+}
+
+// printFile prints f with the options, simplifying it first if asked
+// to.
+func printFile(fset *token.FileSet, f *ast.File, opts FormatOptions) ([]byte, error) {
+	if opts.Simplify {
+		simplify(f)
+	}
+
+	printerMode := printer.UseSpaces
+	if opts.TabIndent {
+		printerMode |= printer.TabIndent
+	}
+	cfg := &printer.Config{Mode: printerMode, Tabwidth: opts.TabWidth}
+	var buf bytes.Buffer
+	if err := cfg.Fprint(&buf, fset, f); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// FormatOptions returns the format settings of the room.
+func (r *Room) FormatOptions() FormatOptions {
+	r.Lock()
+	defer r.Unlock()
+	return r.format
+}
+
+func (r *Room) SetFormatOptions(o FormatOptions) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.format = o
+	return nil
+}
+
+// importGroup returns the group of an import path: the standard
+// library, other packages and the packages starting with one of the
+// local prefixes.
+func importGroup(path string, local []string) int {
+	for _, p := range local {
+		if strings.HasPrefix(path, p) {
+			return 2
+		}
+	}
+	if strings.Contains(strings.SplitN(path, "/", 2)[0], ".") {
+		return 1
+	}
+	return 0
+}
 
-	return out, nil
+// groupImports rewrites the import blocks of src into groups separated
+// by blank lines. Blocks with comments of their own, not attached to an
+// import, are left alone.
+func groupImports(src []byte, local []string) []byte {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, progFile, src, parser.ParseComments|parser.ImportsOnly)
+	if err != nil {
+		return src
+	}
+	offset := func(p token.Pos) int { return fset.Position(p).Offset }
+
+	type imp struct {
+		path  string
+		group int
+		text  string
+	}
+
+	var out bytes.Buffer
+	last := 0
+	for _, decl := range f.Decls {
+		d, ok := decl.(*ast.GenDecl)
+		if !ok || d.Tok != token.IMPORT || !d.Lparen.IsValid() {
+			continue
+		}
+
+		attached := make(map[*ast.CommentGroup]bool)
+		var imps []imp
+		for _, spec := range d.Specs {
+			s := spec.(*ast.ImportSpec)
+			start, end := s.Pos(), s.End()
+			if s.Doc != nil {
+				start = s.Doc.Pos()
+				attached[s.Doc] = true
+			}
+			if s.Comment != nil {
+				end = s.Comment.End()
+				attached[s.Comment] = true
+			}
+			path, _ := strconv.Unquote(s.Path.Value)
+			imps = append(imps, imp{path, importGroup(path, local), string(src[offset(start):offset(end)])})
+		}
+		free := false
+		for _, c := range f.Comments {
+			free = free || c.Pos() > d.Lparen && c.End() < d.Rparen && !attached[c]
+		}
+		if free {
+			continue
+		}
+
+		sort.SliceStable(imps, func(i, j int) bool {
+			if imps[i].group != imps[j].group {
+				return imps[i].group < imps[j].group
+			}
+			return imps[i].path < imps[j].path
+		})
+		out.Write(src[last : offset(d.Lparen)+1])
+		for i, m := range imps {
+			if i > 0 && m.group != imps[i-1].group {
+				out.WriteString("\n")
+			}
+			out.WriteString("\n\t" + m.text)
+		}
+		out.WriteString("\n")
+		last = offset(d.Rparen)
+	}
+	out.Write(src[last:])
+	return out.Bytes()
 }
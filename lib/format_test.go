@@ -0,0 +1,136 @@
+package lib
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	src := `package main
+import (
+	"example.com/me/util"
+	"github.com/other/pkg"
+)
+type T struct{ A int }
+func main() {
+	// comment
+	s := []T{T{1}, T{2}}
+	p := []*T{&T{3}}
+	for i, _ := range s[1:len(s)] { fmt.Println(i, p, util.X, pkg.Y) }
+}
+`
+
+	tests := []struct {
+		name string
+		opts FormatOptions
+		want string
+	}{
+		{"default", DefaultFormatOptions, `package main
+
+import (
+	"fmt"
+
+	"example.com/me/util"
+	"github.com/other/pkg"
+)
+
+type T struct{ A int }
+
+func main() {
+	// comment
+	s := []T{T{1}, T{2}}
+	p := []*T{&T{3}}
+	for i, _ := range s[1:len(s)] {
+		fmt.Println(i, p, util.X, pkg.Y)
+	}
+}
+`},
+		{"all", FormatOptions{TabWidth: 2, Comments: true, Simplify: true, LocalPrefixes: []string{"example.com/me"}}, `package main
+
+import (
+  "fmt"
+
+  "github.com/other/pkg"
+
+  "example.com/me/util"
+)
+
+type T struct{ A int }
+
+func main() {
+  // comment
+  s := []T{{1}, {2}}
+  p := []*T{{3}}
+  for i := range s[1:] {
+    fmt.Println(i, p, util.X, pkg.Y)
+  }
+}
+`},
+		{"format only", FormatOptions{TabWidth: 8, TabIndent: true, Comments: true, FormatOnly: true}, `package main
+
+import (
+	"example.com/me/util"
+	"github.com/other/pkg"
+)
+
+type T struct{ A int }
+
+func main() {
+	// comment
+	s := []T{T{1}, T{2}}
+	p := []*T{&T{3}}
+	for i, _ := range s[1:len(s)] {
+		fmt.Println(i, p, util.X, pkg.Y)
+	}
+}
+`},
+	}
+	for _, tt := range tests {
+		out, err := Format([]byte(src), tt.opts)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(out) != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, out, tt.want)
+		}
+	}
+
+	if err := (FormatOptions{TabWidth: 0}).Validate(); err == nil {
+		t.Error("tab width 0 accepted")
+	}
+}
+
+func TestFormatRange(t *testing.T) {
+	src := "package main\n\nfunc main() {\n\tif x {\n  y:=[]int{1,2}\n    z ( y )\n\t}\n\tbroken(\n}\n\ntype T struct{A int;B string}\n"
+
+	out, err := FormatRange([]byte(src), 4, 5, DefaultFormatOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "package main\n\nfunc main() {\n\tif x {\n\t\ty := []int{1, 2}\n\t\tz(y)\n\t}\n\tbroken(\n}\n\ntype T struct{A int;B string}\n"
+	if string(out) != want {
+		t.Errorf("statements: got\n%s\nwant\n%s", out, want)
+	}
+
+	out, err = FormatRange([]byte(src), 10, 10, DefaultFormatOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = src[:len(src)-len("type T struct{A int;B string}\n")] + "type T struct {\n\tA int\n\tB string\n}\n"
+	if string(out) != want {
+		t.Errorf("declaration: got\n%s\nwant\n%s", out, want)
+	}
+
+	if _, err := FormatRange([]byte(src), 7, 7, DefaultFormatOptions); err == nil {
+		t.Error("broken range formatted")
+	}
+
+	// Lines of raw strings keep their indentation.
+	src = "package main\n\nfunc main() {\ns := `a\n\tb\n  c`\n\tprint( s )\n}\n"
+	out, err = FormatRange([]byte(src), 3, 6, DefaultFormatOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "package main\n\nfunc main() {\n\ts := `a\n\tb\n  c`\n\tprint(s)\n}\n"
+	if string(out) != want {
+		t.Errorf("raw string: got\n%s\nwant\n%s", out, want)
+	}
+}
@@ -0,0 +1,204 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultFuzzTime = 10 * time.Second
+	MaxFuzzTime     = 2 * time.Minute
+)
+
+// FuzzTime returns how long a fuzz session asked to last d runs: the
+// default for no time, at most MaxFuzzTime.
+func FuzzTime(d time.Duration) time.Duration {
+	if d <= 0 {
+		return DefaultFuzzTime
+	}
+	if d > MaxFuzzTime {
+		return MaxFuzzTime
+	}
+	return d
+}
+
+// FuzzProgress is one of the status lines printed by go test -fuzz.
+type FuzzProgress struct {
+	Target         string
+	Elapsed        int
+	Execs          int64
+	ExecsPerSec    int64
+	NewInteresting int
+	Total          int
+}
+
+// FuzzFailure is a failing input found by the fuzzer. Input is the
+// minimized reproducer in the corpus file format.
+type FuzzFailure struct {
+	Target string
+	Input  string
+	Output string
+}
+
+var (
+	fuzzProgressRe = regexp.MustCompile(`^fuzz: elapsed: (\d+)s, execs: (\d+) \((\d+)/sec\), new interesting: (\d+) \(total: (\d+)\)`)
+	fuzzFailureRe  = regexp.MustCompile(`Failing input written to testdata/fuzz/(\S+)`)
+	fuzzSeedRe     = regexp.MustCompile(`failure while testing seed corpus entry: (\S+)`)
+)
+
+// FuzzTargets returns the names of the fuzz functions declared in src.
+func FuzzTargets(src []byte) ([]string, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), progFile, src, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, d := range f.Decls {
+		fn, ok := d.(*ast.FuncDecl)
+		if ok && fn.Recv == nil && strings.HasPrefix(fn.Name.Name, "Fuzz") && IsTestFunc(fn.Name.Name) {
+			names = append(names, fn.Name.Name)
+		}
+	}
+	return names, nil
+}
+
+// Fuzz runs the fuzz target of src for FuzzTime(d), calling progress
+// for every status line. The corpus is read from and saved back to the
+// room, so that later sessions pick up where this one stopped. The returned
+// failure is nil when the fuzzer found nothing.
+func Fuzz(ctx context.Context, tc Toolchain, r *Room, src []byte, target string, d time.Duration, progress func(FuzzProgress)) ([]byte, *FuzzFailure, error) {
+	if target == "" {
+		names, err := FuzzTargets(src)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(names) == 0 {
+			return nil, nil, errors.New("no fuzz target found")
+		}
+		target = names[0]
+	}
+	d = FuzzTime(d)
+
+	p, err := NewProgram(tc, src)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer p.Remove()
+
+	seeds := r.Path("fuzz", "testdata")
+	r.corpus.Lock()
+	err = copyDir(seeds, p.Path("testdata", "fuzz"))
+	r.corpus.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pattern := "^" + regexp.QuoteMeta(target) + "$"
+	cmd := p.Go(ctx, "test", "-run="+pattern, "-fuzz="+pattern, "-fuzztime="+d.String(),
+		"-args", "-test.fuzzcachedir="+r.Path("fuzz", "cache"))
+
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+	go func() {
+		pw.CloseWithError(cmd.Wait())
+	}()
+
+	var out bytes.Buffer
+	var failure *FuzzFailure
+	s := bufio.NewScanner(pr)
+	for s.Scan() {
+		line := s.Text()
+		out.WriteString(line + "\n")
+
+		if m := fuzzProgressRe.FindStringSubmatch(line); m != nil && progress != nil {
+			progress(parseFuzzProgress(target, m))
+		}
+		m := fuzzFailureRe.FindStringSubmatch(line)
+		if m == nil {
+			// Failing inputs kept from an earlier session fail again
+			// before any fuzzing happens.
+			m = fuzzSeedRe.FindStringSubmatch(line)
+		}
+		if m != nil && failure == nil {
+			input, _ := ioutil.ReadFile(p.Path("testdata", "fuzz", filepath.FromSlash(m[1])))
+			failure = &FuzzFailure{Target: target, Input: string(input)}
+		}
+	}
+	io.Copy(ioutil.Discard, pr)
+
+	if failure != nil {
+		failure.Output = out.String()
+	}
+
+	r.corpus.Lock()
+	defer r.corpus.Unlock()
+	if err := copyDir(p.Path("testdata", "fuzz"), seeds); err != nil {
+		return out.Bytes(), failure, err
+	}
+	return out.Bytes(), failure, nil
+}
+
+func parseFuzzProgress(target string, m []string) FuzzProgress {
+	n := func(s string) int64 {
+		i, _ := strconv.ParseInt(s, 10, 64)
+		return i
+	}
+	return FuzzProgress{
+		Target:         target,
+		Elapsed:        int(n(m[1])),
+		Execs:          n(m[2]),
+		ExecsPerSec:    n(m[3]),
+		NewInteresting: int(n(m[4])),
+		Total:          int(n(m[5])),
+	}
+}
+
+func (p FuzzProgress) String() string {
+	return fmt.Sprintf("%s: %ds, %d execs (%d/sec), %d new interesting (total %d)",
+		p.Target, p.Elapsed, p.Execs, p.ExecsPerSec, p.NewInteresting, p.Total)
+}
+
+// copyDir copies the regular files of the tree at src into dst. A
+// missing src is not an error.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == src {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, data, 0644)
+	})
+}
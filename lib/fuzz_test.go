@@ -0,0 +1,53 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFuzzTargets(t *testing.T) {
+	src := []byte(`package main
+
+import "testing"
+
+func Fuzzy() {}
+
+func FuzzParse(f *testing.F) {}
+
+func FuzzReverse(f *testing.F) {}
+`)
+	names, err := FuzzTargets(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "FuzzParse" || names[1] != "FuzzReverse" {
+		t.Errorf("got %v want [FuzzParse FuzzReverse]", names)
+	}
+}
+
+func TestParseFuzzProgress(t *testing.T) {
+	line := "fuzz: elapsed: 3s, execs: 349196 (116378/sec), new interesting: 12 (total: 15)"
+	m := fuzzProgressRe.FindStringSubmatch(line)
+	if m == nil {
+		t.Fatalf("no match for %q", line)
+	}
+
+	p := parseFuzzProgress("FuzzX", m)
+	want := FuzzProgress{Target: "FuzzX", Elapsed: 3, Execs: 349196, ExecsPerSec: 116378, NewInteresting: 12, Total: 15}
+	if p != want {
+		t.Errorf("got %v want %v", p, want)
+	}
+}
+
+func TestFuzzTime(t *testing.T) {
+	for _, tt := range []struct{ d, want time.Duration }{
+		{0, DefaultFuzzTime},
+		{-time.Second, DefaultFuzzTime},
+		{30 * time.Second, 30 * time.Second},
+		{time.Hour, MaxFuzzTime},
+	} {
+		if got := FuzzTime(tt.d); got != tt.want {
+			t.Errorf("FuzzTime(%v) = %v, want %v", tt.d, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package lib
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRunHistory is the number of runs a room keeps the output of.
+	maxRunHistory = 10
+	// maxRunOutput is the output kept of a run, in bytes.
+	maxRunOutput = 32 << 10
+)
+
+// RunRecord is a finished run of a room. Source is the SourceHash of
+// the document that ran, User the name of who started it. Output is the
+// stdout and stderr of the program as it arrived.
+type RunRecord struct {
+	ID        int
+	Source    string
+	User      string
+	Started   time.Time
+	Duration  time.Duration
+	ExitCode  int
+	Status    string
+	Output    string
+	Truncated bool
+}
+
+// RunRecorder collects the output of a run while it runs.
+type RunRecorder struct {
+	mu  sync.Mutex
+	rec RunRecord
+	out []byte
+}
+
+func NewRunRecorder(user string, src []byte) *RunRecorder {
+	return &RunRecorder{
+		rec: RunRecord{
+			Source:  SourceHash(src),
+			User:    user,
+			Started: time.Now(),
+		},
+	}
+}
+
+func (r *RunRecorder) Write(data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if n := maxRunOutput - len(r.out); len(data) > n {
+		data = data[:n]
+		r.rec.Truncated = true
+	}
+	r.out = append(r.out, data...)
+}
+
+// Record returns the record of the run, ended now.
+func (r *RunRecorder) Record(exitCode int, status string) RunRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec := r.rec
+	rec.Duration = time.Since(rec.Started)
+	rec.ExitCode = exitCode
+	rec.Status = status
+	rec.Output = string(r.out)
+	return rec
+}
+
+// AddRun adds a finished run to the history of the room and returns it
+// with its ID set.
+func (r *Room) AddRun(rec RunRecord) RunRecord {
+	r.Lock()
+	defer r.Unlock()
+	r.lastRun++
+	rec.ID = r.lastRun
+	r.history = append(r.history, rec)
+	if len(r.history) > maxRunHistory {
+		r.history = r.history[len(r.history)-maxRunHistory:]
+	}
+	return rec
+}
+
+// RunHistory returns the last runs of the room, oldest first.
+func (r *Room) RunHistory() []RunRecord {
+	r.Lock()
+	defer r.Unlock()
+	return append([]RunRecord(nil), r.history...)
+}
+
+// DiffRuns returns the difference between the output of two runs of the
+// history.
+func (r *Room) DiffRuns(a, b int) (string, error) {
+	// The outputs are diffed without holding the room.
+	r.Lock()
+	var out [2]*string
+	for i := range r.history {
+		output := r.history[i].Output
+		if r.history[i].ID == a {
+			out[0] = &output
+		}
+		if r.history[i].ID == b {
+			out[1] = &output
+		}
+	}
+	r.Unlock()
+	for i, id := range []int{a, b} {
+		if out[i] == nil {
+			return "", fmt.Errorf("run #%d is not in the history", id)
+		}
+	}
+	return DiffText(*out[0], *out[1]), nil
+}
@@ -0,0 +1,54 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRunHistory(t *testing.T) {
+	r, err := NewRoom("history", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < maxRunHistory+2; i++ {
+		rec := NewRunRecorder("U-00", []byte("package main"))
+		rec.Write([]byte("a\n"))
+		if i%2 == 1 {
+			rec.Write([]byte("b\n"))
+		}
+		r.AddRun(rec.Record(0, "Program exited."))
+	}
+
+	runs := r.RunHistory()
+	if len(runs) != maxRunHistory || runs[0].ID != 3 || runs[len(runs)-1].ID != maxRunHistory+2 {
+		t.Fatalf("got %d runs from #%d", len(runs), runs[0].ID)
+	}
+	if runs[0].User != "U-00" || runs[0].Source != SourceHash([]byte("package main")) || runs[0].Output != "a\n" {
+		t.Errorf("got %+v", runs[0])
+	}
+
+	diff, err := r.DiffRuns(3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff != " a\n+b\n" {
+		t.Errorf("got diff %q", diff)
+	}
+	diff, err = r.DiffRuns(4, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff != " a\n b\n" {
+		t.Errorf("got diff %q", diff)
+	}
+	if _, err := r.DiffRuns(1, 4); err == nil {
+		t.Error("diff with a dropped run")
+	}
+
+	rec := NewRunRecorder("", nil)
+	rec.Write([]byte(strings.Repeat("x", maxRunOutput+1)))
+	if run := rec.Record(1, ""); len(run.Output) != maxRunOutput || !run.Truncated {
+		t.Errorf("output of %d bytes kept", len(run.Output))
+	}
+}
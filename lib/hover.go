@@ -0,0 +1,251 @@
+package lib
+
+import (
+	"context"
+	"go/ast"
+	"go/doc"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// HoverInfo describes the expression at a position of the document:
+// its type, the declaration of the object it refers to, if any, and
+// its doc comment. Start and End are the byte offsets of the
+// expression.
+type HoverInfo struct {
+	Expr  string
+	Type  string `json:",omitempty"`
+	Decl  string `json:",omitempty"`
+	Doc   string `json:",omitempty"`
+	Start int
+	End   int
+}
+
+// Hover returns what is known of the identifier or expression at a
+// byte offset of the document, or nil if there is nothing there.
+func Hover(ctx context.Context, tc Toolchain, src []byte, offset int) (*HoverInfo, error) {
+	a, err := Analyze(ctx, tc, src)
+	if err != nil {
+		return nil, err
+	}
+	pos := a.Pos(offset)
+	path, _ := astutil.PathEnclosingInterval(a.File, pos, pos)
+	if len(path) == 0 {
+		return nil, nil
+	}
+
+	var expr ast.Expr
+	var obj types.Object
+	if id, ok := path[0].(*ast.Ident); ok {
+		expr, obj = id, a.Info.ObjectOf(id)
+		// The package of a qualified identifier is part of it.
+		if len(path) > 1 {
+			if sel, ok := path[1].(*ast.SelectorExpr); ok && sel.Sel == id {
+				expr = sel
+			}
+		}
+	} else {
+		for _, n := range path {
+			if e, ok := n.(ast.Expr); ok && a.Info.TypeOf(e) != nil {
+				expr = e
+				break
+			}
+		}
+	}
+	if expr == nil {
+		return nil, nil
+	}
+
+	h := &HoverInfo{
+		Expr:  types.ExprString(expr),
+		Start: a.Offset(expr.Pos()),
+		End:   a.Offset(expr.End()),
+	}
+	if t := a.Info.TypeOf(expr); t != nil && t != types.Typ[types.Invalid] {
+		h.Type = types.TypeString(t, a.qualifier)
+	}
+	if obj != nil {
+		h.Decl = types.ObjectString(obj, a.qualifier)
+		if c, ok := obj.(*types.Const); ok {
+			h.Decl += " = " + c.Val().String()
+		}
+		h.Doc = a.Doc(obj)
+		if _, ok := obj.(*types.PkgName); ok {
+			h.Type = ""
+		}
+	}
+	if tv, ok := a.Info.Types[expr]; ok && tv.Value != nil && obj == nil {
+		h.Type += " = " + tv.Value.ExactString()
+	}
+	return h, nil
+}
+
+// docCache keeps the docs of the packages of the standard library that
+// were looked up, by toolchain.
+var docCache = NewCache(8<<20, time.Hour)
+
+// stdDocs returns the docs of a package of the standard library, built
+// by go/doc from the sources in the GOROOT of tc like godoc does. They
+// are keyed by docKey, the package doc by "".
+func stdDocs(tc Toolchain, importPath string) map[string]string {
+	v, _ := docCache.Do(context.Background(), CacheKey("doc", tc, []byte(importPath)), func() (interface{}, error) {
+		docs := make(map[string]string)
+		root := tc.root()
+		if root == "" {
+			return docs, nil
+		}
+		fset := token.NewFileSet()
+		dir := filepath.Join(root, "src", filepath.FromSlash(importPath))
+		pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+			return !strings.HasSuffix(fi.Name(), "_test.go")
+		}, parser.ParseComments)
+		if err != nil {
+			return docs, nil
+		}
+		for name, pkg := range pkgs {
+			if name == "main" || name == "documentation" {
+				continue
+			}
+			var files []*ast.File
+			for _, f := range pkg.Files {
+				files = append(files, f)
+			}
+			if p, err := doc.NewFromFiles(fset, files, importPath); err == nil {
+				addDocs(docs, p)
+			}
+			break
+		}
+		return docs, nil
+	})
+	docs, _ := v.(map[string]string)
+	return docs
+}
+
+// addDocs adds the docs of the members of p to docs.
+func addDocs(docs map[string]string, p *doc.Package) {
+	docs[""] = p.Doc
+	values := func(vs []*doc.Value) {
+		for _, v := range vs {
+			// Specs of a group have their own doc or the group's.
+			for _, spec := range v.Decl.Specs {
+				vs := spec.(*ast.ValueSpec)
+				text := commentText(vs.Doc, vs.Comment)
+				if text == "" {
+					text = v.Doc
+				}
+				for _, n := range vs.Names {
+					docs[n.Name] = text
+				}
+			}
+		}
+	}
+	funcs := func(prefix string, fs []*doc.Func) {
+		for _, f := range fs {
+			docs[prefix+f.Name] = f.Doc
+		}
+	}
+
+	values(p.Consts)
+	values(p.Vars)
+	funcs("", p.Funcs)
+	for _, t := range p.Types {
+		docs[t.Name] = t.Doc
+		values(t.Consts)
+		values(t.Vars)
+		// Constructors are listed with their type.
+		funcs("", t.Funcs)
+		funcs(t.Name+".", t.Methods)
+
+		// Fields and interface methods.
+		var fields *ast.FieldList
+		for _, spec := range t.Decl.Specs {
+			if ts, ok := spec.(*ast.TypeSpec); ok && ts.Name.Name == t.Name {
+				switch tt := ts.Type.(type) {
+				case *ast.StructType:
+					fields = tt.Fields
+				case *ast.InterfaceType:
+					fields = tt.Methods
+				}
+			}
+		}
+		if fields == nil {
+			continue
+		}
+		for _, f := range fields.List {
+			for _, n := range f.Names {
+				docs[t.Name+"."+n.Name] = commentText(f.Doc, f.Comment)
+			}
+		}
+	}
+}
+
+// docKey returns the key of the doc of obj, a member of a package of
+// the standard library, in stdDocs: its name, prefixed with the name
+// of its type for methods and fields. It is "" for objects that are not
+// documented, like local variables.
+func docKey(obj types.Object) string {
+	switch obj := obj.(type) {
+	case *types.Func:
+		sig, _ := obj.Type().(*types.Signature)
+		if sig == nil || sig.Recv() == nil {
+			return obj.Name()
+		}
+		recv := sig.Recv().Type()
+		if p, ok := recv.(*types.Pointer); ok {
+			recv = p.Elem()
+		}
+		if named, ok := recv.(*types.Named); ok {
+			return named.Obj().Name() + "." + obj.Name()
+		}
+		// Methods of interfaces are named by their interface type.
+		return fieldKey(obj)
+	case *types.Var:
+		if obj.IsField() {
+			return fieldKey(obj)
+		}
+		if obj.Parent() == obj.Pkg().Scope() {
+			return obj.Name()
+		}
+	case *types.Const:
+		if obj.Parent() == obj.Pkg().Scope() {
+			return obj.Name()
+		}
+	case *types.TypeName:
+		return obj.Name()
+	}
+	return ""
+}
+
+// fieldKey returns the key of a field or interface method, named by the
+// type of the package declaring it.
+func fieldKey(obj types.Object) string {
+	scope := obj.Pkg().Scope()
+	for _, name := range scope.Names() {
+		tn, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok {
+			continue
+		}
+		switch u := tn.Type().Underlying().(type) {
+		case *types.Struct:
+			for i := 0; i < u.NumFields(); i++ {
+				if u.Field(i) == obj {
+					return name + "." + obj.Name()
+				}
+			}
+		case *types.Interface:
+			for i := 0; i < u.NumExplicitMethods(); i++ {
+				if u.ExplicitMethod(i) == obj {
+					return name + "." + obj.Name()
+				}
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,95 @@
+package lib
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestHover(t *testing.T) {
+	src := `package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Greeting is what main prints.
+const Greeting = "hello"
+
+type Point struct {
+	// X is the abscissa.
+	X int
+}
+
+func main() {
+	p := Point{X: 1 << 3}
+	fmt.Println(strings.ToUpper(Greeting), p.X)
+	var b strings.Builder
+	b.WriteString("")
+	var u url.URL
+	_ = u.RawQuery
+	var s sort.Interface
+	s.Len()
+}
+`
+	hover := func(marker string, after int) *HoverInfo {
+		i := strings.Index(src, marker)
+		if i < 0 {
+			t.Fatalf("no %q in source", marker)
+		}
+		h, err := Hover(context.Background(), Toolchain{}, []byte(src), i+after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h == nil {
+			t.Fatalf("no hover info at %q", marker)
+		}
+		return h
+	}
+
+	h := hover("Println", 2)
+	if h.Expr != "fmt.Println" || !strings.HasPrefix(h.Decl, "func fmt.Println(") || !strings.HasPrefix(h.Doc, "Println formats") {
+		t.Errorf("fmt.Println: %+v", h)
+	}
+	if got := src[h.Start:h.End]; got != "fmt.Println" {
+		t.Errorf("range of fmt.Println is %q", got)
+	}
+
+	h = hover("strings.ToUpper", 1)
+	if h.Decl != "package strings" || !strings.Contains(h.Doc, "UTF-8") {
+		t.Errorf("package strings: %+v", h)
+	}
+
+	h = hover("WriteString", 0)
+	if !strings.Contains(h.Decl, "(*strings.Builder).WriteString") || !strings.HasPrefix(h.Doc, "WriteString appends") {
+		t.Errorf("method WriteString: %+v", h)
+	}
+
+	h = hover("Greeting)", 0)
+	if h.Type != "string" || h.Decl != `const Greeting untyped string = "hello"` || h.Doc != "Greeting is what main prints.\n" {
+		t.Errorf("const Greeting: %+v", h)
+	}
+
+	h = hover("p.X", 2)
+	if h.Expr != "p.X" || h.Type != "int" || h.Decl != "field X int" || h.Doc != "X is the abscissa.\n" {
+		t.Errorf("field p.X: %+v", h)
+	}
+
+	h = hover("RawQuery", 0)
+	if !strings.HasPrefix(h.Doc, "RawQuery contains the encoded query") {
+		t.Errorf("field url.URL.RawQuery: %+v", h)
+	}
+
+	h = hover("Len()", 0)
+	if !strings.HasPrefix(h.Doc, "Len is the number") {
+		t.Errorf("method sort.Interface.Len: %+v", h)
+	}
+
+	h = hover("1 << 3", 2)
+	if h.Expr != "1 << 3" || h.Type != "int = 8" {
+		t.Errorf("constant expression: %+v", h)
+	}
+}
@@ -0,0 +1,251 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// ImportIndex holds the exported names of the packages in extra roots,
+// so that formatting can import them like the standard library.
+type ImportIndex struct {
+	// pkgs are the packages by name.
+	pkgs map[string][]*indexedPkg
+	// names are the package names by import path.
+	names map[string]string
+}
+
+type indexedPkg struct {
+	path    string
+	exports map[string]bool
+}
+
+// NewImportIndex indexes the packages in roots. A root with a go.mod
+// file is a module, its packages are imported with the module path.
+// Other roots are laid out like GOPATH, with or without the src
+// directory.
+func NewImportIndex(roots []string) (*ImportIndex, error) {
+	ix := &ImportIndex{pkgs: make(map[string][]*indexedPkg), names: make(map[string]string)}
+	for _, root := range roots {
+		prefix := ""
+		if data, err := ioutil.ReadFile(filepath.Join(root, "go.mod")); err == nil {
+			prefix = modulePath(data)
+		} else if fi, err := os.Stat(filepath.Join(root, "src")); err == nil && fi.IsDir() {
+			root = filepath.Join(root, "src")
+		}
+		if err := ix.addRoot(root, prefix); err != nil {
+			return nil, err
+		}
+	}
+	for _, pkgs := range ix.pkgs {
+		sort.Sort(byImportPath(pkgs))
+	}
+	return ix, nil
+}
+
+// modulePath returns the module path declared by a go.mod file.
+func modulePath(gomod []byte) string {
+	s := bufio.NewScanner(bytes.NewReader(gomod))
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) >= 2 && f[0] == "module" {
+			if p, err := strconv.Unquote(f[1]); err == nil {
+				return p
+			}
+			return f[1]
+		}
+	}
+	return ""
+}
+
+func (ix *ImportIndex) addRoot(root, prefix string) error {
+	return filepath.Walk(root, func(dir string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		// Documents are built in a module of their own, which may not
+		// import internal or vendored packages of other modules.
+		name := fi.Name()
+		if dir != root && (name == "testdata" || name == "vendor" || name == "internal" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+			return filepath.SkipDir
+		}
+		// Nested modules are indexed on their own, if at all.
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil && dir != root {
+			return filepath.SkipDir
+		}
+
+		rel, err := filepath.Rel(root, dir)
+		if err != nil {
+			return err
+		}
+		importPath := path.Join(prefix, filepath.ToSlash(rel))
+		if importPath == "." || importPath == "" || !importable(importPath) {
+			return nil
+		}
+		ix.addPackage(dir, importPath)
+		return nil
+	})
+}
+
+// importable reports whether a package of another module may be
+// imported from its path, which has no internal or vendor element.
+func importable(importPath string) bool {
+	for _, elem := range strings.Split(importPath, "/") {
+		if elem == "internal" || elem == "vendor" {
+			return false
+		}
+	}
+	return true
+}
+
+// addPackage indexes the package in dir, if any.
+func (ix *ImportIndex) addPackage(dir, importPath string) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, parser.SkipObjectResolution)
+	if err != nil {
+		return
+	}
+	for name, p := range pkgs {
+		if name == "main" {
+			continue
+		}
+		pkg := &indexedPkg{path: importPath, exports: make(map[string]bool)}
+		for _, f := range p.Files {
+			for _, decl := range f.Decls {
+				switch d := decl.(type) {
+				case *ast.FuncDecl:
+					if d.Recv == nil && d.Name.IsExported() {
+						pkg.exports[d.Name.Name] = true
+					}
+				case *ast.GenDecl:
+					for _, spec := range d.Specs {
+						switch s := spec.(type) {
+						case *ast.TypeSpec:
+							if s.Name.IsExported() {
+								pkg.exports[s.Name.Name] = true
+							}
+						case *ast.ValueSpec:
+							for _, n := range s.Names {
+								if n.IsExported() {
+									pkg.exports[n.Name] = true
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+		ix.pkgs[name] = append(ix.pkgs[name], pkg)
+		ix.names[importPath] = name
+	}
+}
+
+// pkgName returns the name of the package imported from p, as indexed
+// or else assumed from p.
+func (ix *ImportIndex) pkgName(p string) string {
+	if name, ok := ix.names[p]; ok {
+		return name
+	}
+	return assumedName(p)
+}
+
+// assumedName returns the name a package is assumed to have from its
+// import path, like goimports does: the last element that is not a
+// major version suffix like "v2".
+func assumedName(p string) string {
+	name := path.Base(p)
+	if isMajorVersion(name) {
+		name = path.Base(path.Dir(p))
+	}
+	return name
+}
+
+// find returns the import path of the package called name exporting
+// all the symbols, the shortest one if there are several.
+func (ix *ImportIndex) find(name string, symbols map[string]bool) (string, bool) {
+	for _, pkg := range ix.pkgs[name] {
+		ok := true
+		for sym := range symbols {
+			ok = ok && pkg.exports[sym]
+		}
+		if ok {
+			return pkg.path, true
+		}
+	}
+	return "", false
+}
+
+// addImports imports the packages of the index that f refers to but
+// doesn't import. It reports whether it added any.
+func (ix *ImportIndex) addImports(fset *token.FileSet, f *ast.File) bool {
+	imported := make(map[string]bool)
+	for _, spec := range f.Imports {
+		p, _ := strconv.Unquote(spec.Path.Value)
+		if spec.Name != nil {
+			imported[spec.Name.Name] = true
+		} else {
+			imported[ix.pkgName(p)] = true
+		}
+	}
+
+	// The references to undeclared names, by package name.
+	refs := make(map[string]map[string]bool)
+	ast.Inspect(f, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		x, ok := sel.X.(*ast.Ident)
+		if !ok || x.Obj != nil || imported[x.Name] || types.Universe.Lookup(x.Name) != nil {
+			return true
+		}
+		if refs[x.Name] == nil {
+			refs[x.Name] = make(map[string]bool)
+		}
+		refs[x.Name][sel.Sel.Name] = true
+		return true
+	})
+
+	added := false
+	for name, symbols := range refs {
+		p, ok := ix.find(name, symbols)
+		if !ok {
+			continue
+		}
+		if assumedName(p) == name {
+			astutil.AddImport(fset, f, p)
+		} else {
+			astutil.AddNamedImport(fset, f, name, p)
+		}
+		added = true
+	}
+	return added
+}
+
+type byImportPath []*indexedPkg
+
+func (s byImportPath) Len() int      { return len(s) }
+func (s byImportPath) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byImportPath) Less(i, j int) bool {
+	if len(s[i].path) != len(s[j].path) {
+		return len(s[i].path) < len(s[j].path)
+	}
+	return s[i].path < s[j].path
+}
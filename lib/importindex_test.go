@@ -0,0 +1,95 @@
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImportIndex(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("mod/go.mod", "module example.com/team\n")
+	write("mod/util/util.go", "package util\n\nfunc Bar() {}\n\nvar Baz int\n")
+	write("mod/go-widget/widget.go", "package widget\n\ntype Widget struct{}\n")
+	write("mod/internal/log/log.go", "package log\n\nfunc Printf(string, ...interface{}) {}\n")
+	write("mod/bar/v2/bar.go", "package bar\n\nfunc X() {}\n")
+	write("gopath/src/corp/util/util.go", "package util\n\nfunc Other() {}\n")
+	write("gopath/src/corp/internal/secret/secret.go", "package secret\n\nfunc Key() {}\n")
+	write("gopath/src/corp/vendor/dep/dep.go", "package dep\n\nfunc Do() {}\n")
+
+	ix, err := NewImportIndex([]string{filepath.Join(dir, "mod"), filepath.Join(dir, "gopath")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := `package main
+
+func main() {
+	util.Bar()
+	_ = util.Baz
+	var w widget.Widget
+	log.Printf("%v", w)
+}
+`
+	opts := DefaultFormatOptions
+	opts.Index = ix
+	out, err := Format([]byte(src), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `package main
+
+import (
+	"log"
+
+	widget "example.com/team/go-widget"
+	"example.com/team/util"
+)
+
+func main() {
+	util.Bar()
+	_ = util.Baz
+	var w widget.Widget
+	log.Printf("%v", w)
+}
+`
+	if string(out) != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+
+	if p, ok := ix.find("util", map[string]bool{"Other": true}); !ok || p != "corp/util" {
+		t.Errorf("got %q, %v for util.Other", p, ok)
+	}
+
+	// The package of a major version path is named after the element
+	// before the version.
+	src = `package main
+
+import "example.com/team/bar/v2"
+
+func main() { bar.X() }
+`
+	if out, err := Format([]byte(src), opts); err != nil || string(out) != src {
+		t.Errorf("got\n%s\n%v, want the source unchanged", out, err)
+	}
+	if p, ok := ix.find("bar", map[string]bool{"X": true}); !ok || p != "example.com/team/bar/v2" {
+		t.Errorf("got %q, %v for bar.X", p, ok)
+	}
+
+	// Internal and vendored packages can't be imported by documents.
+	for name, sym := range map[string]string{"log": "Printf", "secret": "Key", "dep": "Do"} {
+		if p, ok := ix.find(name, map[string]bool{sym: true}); ok {
+			t.Errorf("got %q for %s.%s", p, name, sym)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// AsmFunc is the assembly the compiler generated for a function.
+type AsmFunc struct {
+	Name         string
+	Line         int
+	Instructions []AsmLine
+}
+
+// AsmLine is an instruction and the document line it was generated
+// for. Line is 0 for instructions of functions inlined from other
+// packages.
+type AsmLine struct {
+	Line int
+	Text string
+}
+
+// Annotation is a decision of the compiler about a position of the
+// document, like an inlined call or a value escaping to the heap.
+type Annotation struct {
+	Line    int
+	Col     int
+	Kind    string
+	Message string
+	Details []string
+}
+
+var (
+	asmFuncRe = regexp.MustCompile(`^(\S+) STEXT`)
+	asmInstRe = regexp.MustCompile(`^\t0x[0-9a-f]+ \d+ \(([^)]*):(\d+)\)\t(.*)$`)
+)
+
+// Assembly builds src with -gcflags=-S and returns the assembly of
+// every function of the document.
+func Assembly(ctx context.Context, tc Toolchain, src []byte) ([]AsmFunc, error) {
+	out, err := compileWith(ctx, tc, src, "-S")
+	if err != nil {
+		return nil, err
+	}
+	return ParseAssembly(out), nil
+}
+
+// ParseAssembly parses the output of the compiler's -S flag.
+func ParseAssembly(out []byte) []AsmFunc {
+	var funcs []AsmFunc
+	var fn *AsmFunc
+
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		line := s.Text()
+		if m := asmFuncRe.FindStringSubmatch(line); m != nil {
+			funcs = append(funcs, AsmFunc{Name: m[1]})
+			fn = &funcs[len(funcs)-1]
+			continue
+		}
+		m := asmInstRe.FindStringSubmatch(line)
+		if m == nil || fn == nil {
+			// Data symbols, hex dumps and relocations.
+			continue
+		}
+
+		text := strings.Replace(m[3], "\t", " ", -1)
+		if strings.HasPrefix(text, "PCDATA") || strings.HasPrefix(text, "FUNCDATA") {
+			continue
+		}
+
+		l := 0
+		if strings.HasSuffix(m[1], progFile) {
+			l, _ = strconv.Atoi(m[2])
+		}
+		if fn.Line == 0 {
+			fn.Line = l
+		}
+		fn.Instructions = append(fn.Instructions, AsmLine{Line: l, Text: text})
+	}
+	return funcs
+}
+
+// Optimizations builds src with -gcflags=-m=2 and bounds check
+// reporting and returns the compiler's decisions.
+func Optimizations(ctx context.Context, tc Toolchain, src []byte) ([]Annotation, error) {
+	out, err := compileWith(ctx, tc, src, "-m=2 -d=ssa/check_bce/debug=1")
+	if err != nil {
+		return nil, err
+	}
+	return ParseOptimizations(out), nil
+}
+
+// ParseOptimizations parses the output of the compiler's -m and bounds
+// check debug flags. Indented lines explaining an escape are added to
+// the annotation they belong to.
+func ParseOptimizations(out []byte) []Annotation {
+	var annotations []Annotation
+	for _, d := range ParseDiagnostics(out) {
+		if strings.HasPrefix(d.Message, " ") {
+			if n := len(annotations); n > 0 {
+				annotations[n-1].Details = append(annotations[n-1].Details, strings.TrimSpace(d.Message))
+			}
+			continue
+		}
+		annotations = append(annotations, Annotation{
+			Line:    d.Line,
+			Col:     d.Col,
+			Kind:    annotationKind(d.Message),
+			Message: d.Message,
+		})
+	}
+	return annotations
+}
+
+func annotationKind(msg string) string {
+	switch {
+	case strings.Contains(msg, "escapes to heap"), strings.HasPrefix(msg, "moved to heap"):
+		return "escape"
+	case strings.Contains(msg, "does not escape"), strings.Contains(msg, "leaking param"):
+		return "noescape"
+	case strings.HasPrefix(msg, "inlining call to"):
+		return "inline"
+	case strings.HasPrefix(msg, "can inline"), strings.HasPrefix(msg, "cannot inline"):
+		return "inlinable"
+	case strings.HasPrefix(msg, "Found Is"):
+		return "bounds"
+	}
+	return "other"
+}
+
+// Vet runs go vet on src and returns its findings.
+func Vet(ctx context.Context, tc Toolchain, src []byte) ([]Diagnostic, error) {
+	p, err := NewProgram(tc, src)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Remove()
+
+	out, err := p.Go(ctx, "vet").CombinedOutput()
+	diags := ParseDiagnostics(out)
+	if err != nil && len(diags) == 0 {
+		if len(out) > 0 {
+			return nil, errors.New(string(out))
+		}
+		return nil, err
+	}
+	return diags, nil
+}
+
+// compileWith builds src with gcflags and returns the compiler output.
+// The output of a failed build is returned as the error.
+func compileWith(ctx context.Context, tc Toolchain, src []byte, gcflags string) ([]byte, error) {
+	p, err := NewProgram(tc, src)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Remove()
+
+	out, err := p.Go(ctx, "build", "-o", os.DevNull, "-gcflags="+gcflags).CombinedOutput()
+	if err != nil && len(out) > 0 {
+		return nil, errors.New(string(out))
+	}
+	return out, err
+}
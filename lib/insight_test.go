@@ -0,0 +1,61 @@
+package lib
+
+import "testing"
+
+func TestParseAssembly(t *testing.T) {
+	out := []byte("# prog\n" +
+		"main.f STEXT size=143 args=0x20 locals=0x28\n" +
+		"\t0x0000 00000 (/tmp/gogala1/prog.go:7)\tTEXT\tmain.f(SB), ABIInternal, $40-32\n" +
+		"\t0x0004 00004 (/tmp/gogala1/prog.go:7)\tPCDATA\t$0, $-2\n" +
+		"\t0x000e 00014 (/tmp/gogala1/prog.go:8)\tMOVQ\tBX, main.s+56(SP)\n" +
+		"\t0x0013 00019 (/usr/lib/go/src/fmt/print.go:314)\tCALL\tfmt.Fprintln(SB)\n" +
+		"\t0x0000 49 3b 66 10 76 57 55 48 89 e5 48 83 ec 20 48 89  I;f.vWUH..H.. H.\n" +
+		"\trel 3+0 t=R_USEIFACE type:*os.File+0\n" +
+		"go:cuinfo.producer.main SDWARFCUINFO dupok size=0\n")
+
+	funcs := ParseAssembly(out)
+	if len(funcs) != 1 {
+		t.Fatalf("got %v want 1 function", funcs)
+	}
+
+	fn := funcs[0]
+	if fn.Name != "main.f" || fn.Line != 7 {
+		t.Errorf("got %v at %v want main.f at 7", fn.Name, fn.Line)
+	}
+	want := []AsmLine{
+		{Line: 7, Text: "TEXT main.f(SB), ABIInternal, $40-32"},
+		{Line: 8, Text: "MOVQ BX, main.s+56(SP)"},
+		{Line: 0, Text: "CALL fmt.Fprintln(SB)"},
+	}
+	if len(fn.Instructions) != len(want) {
+		t.Fatalf("got %v want %v", fn.Instructions, want)
+	}
+	for i := range want {
+		if fn.Instructions[i] != want[i] {
+			t.Errorf("got %v want %v", fn.Instructions[i], want[i])
+		}
+	}
+}
+
+func TestParseOptimizations(t *testing.T) {
+	out := []byte("# prog\n" +
+		"./prog.go:13:15: inlining call to f\n" +
+		"./prog.go:8:7: &T{...} escapes to heap in f:\n" +
+		"./prog.go:8:7:   flow: ~r0 ← x:\n" +
+		"./prog.go:7:8: s does not escape\n" +
+		"./prog.go:8:11: Found IsInBounds\n")
+
+	a := ParseOptimizations(out)
+	kinds := []string{"inline", "escape", "noescape", "bounds"}
+	if len(a) != len(kinds) {
+		t.Fatalf("got %v want %v annotations", a, len(kinds))
+	}
+	for i, k := range kinds {
+		if a[i].Kind != k {
+			t.Errorf("got %v want %v", a[i].Kind, k)
+		}
+	}
+	if len(a[1].Details) != 1 || a[1].Details[0] != "flow: ~r0 ← x:" {
+		t.Errorf("got %v want the flow line", a[1].Details)
+	}
+}
@@ -0,0 +1,264 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io"
+	"strconv"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// LiveValue is a value printed or assigned by a program run in live
+// mode, tagged with the document line that produced it. Name is the
+// assigned variable, it is empty for printed output.
+type LiveValue struct {
+	Line  int
+	Name  string
+	Value string
+}
+
+const liveFile = "gogala_live.go"
+
+// liveMinVersion is the first Go 1 release that builds liveHelpers,
+// they use type parameters.
+const liveMinVersion = 18
+
+// liveHelpers is written next to an instrumented program. The values
+// are sent as JSON lines on file descriptor 3, at most liveMaxValues per
+// line so that loops don't flood the room.
+const liveHelpers = `package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+const liveMaxValues = 100
+
+var (
+	liveMu     sync.Mutex
+	liveOut    = json.NewEncoder(os.NewFile(3, "gogala"))
+	liveCounts = make(map[int]int)
+)
+
+func gogalaRecord(line int, name string, value string) {
+	liveMu.Lock()
+	defer liveMu.Unlock()
+	if liveCounts[line]++; liveCounts[line] > liveMaxValues {
+		return
+	}
+	if len(value) > 200 {
+		value = value[:200] + "..."
+	}
+	liveOut.Encode(struct {
+		Line  int
+		Name  string
+		Value string
+	}{line, name, value})
+}
+
+func gogalaFormat(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func gogalaPrint(line int, s string) (int, error) {
+	gogalaRecord(line, "", s)
+	return os.Stdout.WriteString(s)
+}
+
+func gogalaValue[T any](line int, name string, v T) T {
+	gogalaRecord(line, name, gogalaFormat(v))
+	return v
+}
+
+func gogalaSet[T any](line int, name string, p *T, v T) T {
+	gogalaRecord(line, name, gogalaFormat(v))
+	return v
+}
+`
+
+// livePrints are the fmt functions whose output is tagged, with the
+// function formatting the same output into a string.
+var livePrints = map[string]string{
+	"Print":   "Sprint",
+	"Println": "Sprintln",
+	"Printf":  "Sprintf",
+}
+
+// liveAssignOps maps assignment operators to their binary operators.
+var liveAssignOps = map[token.Token]token.Token{
+	token.ADD_ASSIGN:     token.ADD,
+	token.SUB_ASSIGN:     token.SUB,
+	token.MUL_ASSIGN:     token.MUL,
+	token.QUO_ASSIGN:     token.QUO,
+	token.REM_ASSIGN:     token.REM,
+	token.AND_ASSIGN:     token.AND,
+	token.OR_ASSIGN:      token.OR,
+	token.XOR_ASSIGN:     token.XOR,
+	token.SHL_ASSIGN:     token.SHL,
+	token.SHR_ASSIGN:     token.SHR,
+	token.AND_NOT_ASSIGN: token.AND_NOT,
+}
+
+// Instrument rewrites the functions of src so that calls to fmt.Print,
+// Println and Printf and the values assigned to variables are reported
+// with their line. Rewritten expressions keep their position, so the
+// lines of the result match the ones of src.
+//
+// Assignments with several values on the right-hand side, like
+// x, err := f(), and declarations with an explicit type are left alone.
+func Instrument(src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, progFile, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	fmtName := ""
+	if astutil.UsesImport(f, "fmt") {
+		fmtName = "fmt"
+		for _, imp := range f.Imports {
+			if p, _ := strconv.Unquote(imp.Path.Value); p == "fmt" && imp.Name != nil {
+				fmtName = imp.Name.Name
+			}
+		}
+	}
+
+	line := func(n ast.Node) *ast.BasicLit {
+		l := fset.Position(n.Pos()).Line
+		return &ast.BasicLit{ValuePos: n.Pos(), Kind: token.INT, Value: strconv.Itoa(l)}
+	}
+	name := func(id *ast.Ident) *ast.BasicLit {
+		return &ast.BasicLit{ValuePos: id.Pos(), Kind: token.STRING, Value: strconv.Quote(id.Name)}
+	}
+	call := func(fn string, e ast.Expr, args ...ast.Expr) *ast.CallExpr {
+		return &ast.CallExpr{
+			Fun:    &ast.Ident{NamePos: e.Pos(), Name: fn},
+			Lparen: e.Pos(),
+			Args:   append(args, e),
+			Rparen: e.End(),
+		}
+	}
+
+	for _, d := range f.Decls {
+		fn, ok := d.(*ast.FuncDecl)
+		if !ok || fn.Body == nil {
+			continue
+		}
+		// Assignments of select cases and type switches only allow a
+		// receive or a type assertion on the right-hand side.
+		skip := make(map[ast.Node]bool)
+
+		// ast.Inspect walks the children of a node after rewriting it,
+		// so the original expressions inside the wrappers are
+		// instrumented too.
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.GenDecl:
+				return n.Tok == token.VAR
+			case *ast.CommClause:
+				skip[n.Comm] = true
+			case *ast.TypeSwitchStmt:
+				skip[n.Assign] = true
+
+			case *ast.CallExpr:
+				if !isLivePrint(n, fmtName) {
+					break
+				}
+				// fmt.Println(a) becomes gogalaPrint(line, fmt.Sprintln(a)),
+				// in place as the parent of the call is not known.
+				sel := astutil.Unparen(n.Fun).(*ast.SelectorExpr)
+				inner := *n
+				inner.Fun = &ast.SelectorExpr{X: sel.X, Sel: &ast.Ident{NamePos: sel.Sel.Pos(), Name: livePrints[sel.Sel.Name]}}
+				n.Fun = &ast.Ident{NamePos: n.Pos(), Name: "gogalaPrint"}
+				n.Args = []ast.Expr{line(n), &inner}
+				n.Ellipsis = token.NoPos
+
+			case *ast.AssignStmt:
+				if len(n.Lhs) != len(n.Rhs) || skip[n] {
+					break
+				}
+				for i, lhs := range n.Lhs {
+					id, ok := lhs.(*ast.Ident)
+					if !ok || id.Name == "_" {
+						continue
+					}
+					switch op, ok := liveAssignOps[n.Tok]; {
+					case n.Tok == token.DEFINE:
+						n.Rhs[i] = call("gogalaValue", n.Rhs[i], line(n), name(id))
+					case n.Tok == token.ASSIGN:
+						n.Rhs[i] = call("gogalaSet", n.Rhs[i], line(n), name(id), addr(id))
+					case ok:
+						// x op= y is rewritten to x = x op (y), x is an
+						// identifier so it is evaluated once either way.
+						y := n.Rhs[i]
+						n.Tok = token.ASSIGN
+						n.Rhs[i] = call("gogalaSet", &ast.BinaryExpr{
+							X:     &ast.Ident{NamePos: y.Pos(), Name: id.Name},
+							OpPos: y.Pos(),
+							Op:    op,
+							// The position of a token past y could be on the
+							// next line.
+							Y: &ast.ParenExpr{Lparen: y.Pos(), X: y, Rparen: y.End() - 1},
+						}, line(n), name(id), addr(id))
+					}
+				}
+
+			case *ast.ValueSpec:
+				if n.Type != nil || len(n.Names) != len(n.Values) {
+					break
+				}
+				for i, id := range n.Names {
+					if id.Name != "_" {
+						n.Values[i] = call("gogalaValue", n.Values[i], line(n), name(id))
+					}
+				}
+			}
+			return true
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, f); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// isLivePrint reports whether c calls one of livePrints of the fmt
+// package imported as fmtName.
+func isLivePrint(c *ast.CallExpr, fmtName string) bool {
+	sel, ok := astutil.Unparen(c.Fun).(*ast.SelectorExpr)
+	if !ok || fmtName == "" {
+		return false
+	}
+	x, ok := sel.X.(*ast.Ident)
+	return ok && x.Name == fmtName && livePrints[sel.Sel.Name] != ""
+}
+
+func addr(id *ast.Ident) ast.Expr {
+	return &ast.UnaryExpr{OpPos: id.Pos(), Op: token.AND, X: &ast.Ident{NamePos: id.Pos(), Name: id.Name}}
+}
+
+// readLiveValues calls fn for every value an instrumented program
+// writes to r.
+func readLiveValues(r io.Reader, fn func(LiveValue)) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		var v LiveValue
+		if err := json.Unmarshal(s.Bytes(), &v); err == nil {
+			fn(v)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestInstrument(t *testing.T) {
+	src := []byte(`package main
+
+import f "fmt"
+
+func main() {
+	x := 1
+	var y float64
+	y = 2
+	y += 0.5
+	const c = 3
+	for i := 0; i < c; i++ {
+		x *= 2
+	}
+	var i interface{} = x
+	switch v := i.(type) {
+	case int:
+		f.Println(v, y)
+	}
+}
+`)
+	out, err := Instrument(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := bytes.Split(out, []byte("\n"))
+	if want := bytes.Count(src, []byte("\n")) + 1; len(lines) != want {
+		t.Fatalf("got %v lines want %v:\n%s", len(lines), want, out)
+	}
+	for _, tt := range []struct {
+		line int
+		want string
+	}{
+		{6, `x := gogalaValue(6, "x", 1)`},
+		{7, `var y float64`},
+		{8, `y = gogalaSet(8, "y", &y, 2)`},
+		{9, `y = gogalaSet(9, "y", &y, y+(0.5))`},
+		{10, `const c = 3`},
+		{11, `for i := gogalaValue(11, "i", 0); i < c; i++ {`},
+		{14, `var i interface{} = x`},
+		{15, `switch v := i.(type) {`},
+		{17, `gogalaPrint(17, f.Sprintln(v, y))`},
+	} {
+		if got := strings.TrimSpace(string(lines[tt.line-1])); got != tt.want {
+			t.Errorf("line %v: got %q want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestStartLive(t *testing.T) {
+	src := []byte(`package main
+
+import "fmt"
+
+func main() {
+	x := 1
+	for i := 0; i < 3; i++ {
+		x *= 2
+	}
+	fmt.Println("x is", x)
+}
+`)
+	var mu sync.Mutex
+	var values []LiveValue
+	p, err := StartLive(context.Background(), Toolchain{}, src, RunConfig{}, func(string, []byte) {}, func(v LiveValue) {
+		mu.Lock()
+		values = append(values, v)
+		mu.Unlock()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.CloseStdin()
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []LiveValue{
+		{6, "x", "1"},
+		{7, "i", "0"},
+		{8, "x", "2"},
+		{8, "x", "4"},
+		{8, "x", "8"},
+		{10, "", "x is 8\n"},
+	}
+	if len(values) != len(want) {
+		t.Fatalf("got values %v want %v", values, want)
+	}
+	for i := range want {
+		if values[i] != want[i] {
+			t.Errorf("value %v: got %+v want %+v", i, values[i], want[i])
+		}
+	}
+
+	if _, err := StartLive(context.Background(), Toolchain{Version: "go1.17.13"}, src, RunConfig{}, nil, nil); err == nil {
+		t.Error("live mode started with a toolchain without type parameters")
+	}
+}
@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// BuildTarget is a platform and set of build tags the document is
+// compiled for.
+type BuildTarget struct {
+	GOOS   string
+	GOARCH string
+	Tags   []string
+}
+
+// BuildResult is the outcome of compiling the document for a target.
+type BuildResult struct {
+	Target      BuildTarget
+	Passed      bool
+	Output      string
+	Diagnostics []Diagnostic
+}
+
+var DefaultBuildTargets = []BuildTarget{
+	{GOOS: "linux", GOARCH: "amd64"},
+	{GOOS: "linux", GOARCH: "386"},
+	{GOOS: "linux", GOARCH: "arm64"},
+	{GOOS: "darwin", GOARCH: "arm64"},
+	{GOOS: "windows", GOARCH: "amd64"},
+	{GOOS: "js", GOARCH: "wasm"},
+}
+
+// MaxBuildTargets is the number of targets a build matrix may have, as
+// they are all built by one job.
+const MaxBuildTargets = 12
+
+// ValidateBuildTargets checks the targets of a build matrix and that
+// there are at most MaxBuildTargets.
+func ValidateBuildTargets(targets []BuildTarget) error {
+	if len(targets) > MaxBuildTargets {
+		return fmt.Errorf("too many build targets, at most %d can be built at once", MaxBuildTargets)
+	}
+	for _, t := range targets {
+		if err := t.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks that the target names a platform, GOOS and GOARCH
+// are not defaulted to the ones of the server.
+func (t BuildTarget) Validate() error {
+	for _, v := range []string{t.GOOS, t.GOARCH} {
+		if v == "" || strings.Trim(v, "abcdefghijklmnopqrstuvwxyz0123456789") != "" {
+			return fmt.Errorf("bad build target %q", t.GOOS+"/"+t.GOARCH)
+		}
+	}
+	return nil
+}
+
+func (t BuildTarget) String() string {
+	s := t.GOOS + "/" + t.GOARCH
+	if len(t.Tags) > 0 {
+		s += " [" + strings.Join(t.Tags, ",") + "]"
+	}
+	return s
+}
+
+// BuildMatrix type-checks and compiles src, including its tests, for
+// every target without running anything.
+func BuildMatrix(ctx context.Context, tc Toolchain, src []byte, targets []BuildTarget) ([]BuildResult, error) {
+	p, err := NewProgram(tc, src)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Remove()
+
+	var results []BuildResult
+	for _, t := range targets {
+		args := []string{"test", "-c", "-o", os.DevNull, "-vet=off"}
+		if len(t.Tags) > 0 {
+			args = append(args, "-tags="+strings.Join(t.Tags, ","))
+		}
+
+		cmd := p.Go(ctx, args...)
+		cmd.Env = append(cmd.Env, "GOOS="+t.GOOS, "GOARCH="+t.GOARCH, "CGO_ENABLED=0")
+		out, err := cmd.CombinedOutput()
+
+		results = append(results, BuildResult{
+			Target:      t,
+			Passed:      err == nil,
+			Output:      string(out),
+			Diagnostics: ParseDiagnostics(out),
+		})
+	}
+	return results, nil
+}
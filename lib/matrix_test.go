@@ -0,0 +1,36 @@
+package lib
+
+import "testing"
+
+func TestBuildTargetValidate(t *testing.T) {
+	for _, target := range DefaultBuildTargets {
+		if err := target.Validate(); err != nil {
+			t.Errorf("%v: %v", target, err)
+		}
+	}
+	for _, target := range []BuildTarget{
+		{GOOS: "plan9"},
+		{GOARCH: "amd64"},
+		{GOOS: "linux", GOARCH: "amd64 -x"},
+	} {
+		if err := target.Validate(); err == nil {
+			t.Errorf("%+v: got nil want error", target)
+		}
+	}
+}
+
+func TestValidateBuildTargets(t *testing.T) {
+	if err := ValidateBuildTargets(DefaultBuildTargets); err != nil {
+		t.Error(err)
+	}
+	targets := make([]BuildTarget, MaxBuildTargets+1)
+	for i := range targets {
+		targets[i] = BuildTarget{GOOS: "linux", GOARCH: "amd64"}
+	}
+	if err := ValidateBuildTargets(targets); err == nil {
+		t.Errorf("%d targets: got nil want error", len(targets))
+	}
+	if err := ValidateBuildTargets([]BuildTarget{{GOOS: "linux"}}); err == nil {
+		t.Error("bad target: got nil want error")
+	}
+}
@@ -0,0 +1,217 @@
+package lib
+
+import (
+	"context"
+	"go/ast"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// Location is a range of the document, or of a file of the standard
+// library if File is set. Lines count from 1 and columns from 1 in
+// UTF-16 units, like the editor. Source is the content of File.
+type Location struct {
+	File      string `json:",omitempty"`
+	Line      int
+	Column    int
+	EndLine   int
+	EndColumn int
+	Source    string `json:",omitempty"`
+}
+
+// Symbol is a declaration of the document listed in its outline: a
+// "type", "func", "method", "const" or "var". Recv is the receiver type
+// of methods.
+type Symbol struct {
+	Name string
+	Kind string
+	Recv string `json:",omitempty"`
+	Type string `json:",omitempty"`
+	Location
+}
+
+// location returns the location of the bytes start to end of src.
+func location(src []byte, start, end int) Location {
+	lineCol := func(offset int) (int, int) {
+		if offset > len(src) {
+			offset = len(src)
+		}
+		lineStart := strings.LastIndex(string(src[:offset]), "\n") + 1
+		return strings.Count(string(src[:offset]), "\n") + 1, UTF16Offset(src[lineStart:], offset-lineStart) + 1
+	}
+	var l Location
+	l.Line, l.Column = lineCol(start)
+	l.EndLine, l.EndColumn = lineCol(end)
+	return l
+}
+
+// identAt returns the identifier at a byte offset of the document and
+// the object it denotes.
+func (a *Analysis) identAt(offset int) (*ast.Ident, types.Object) {
+	pos := a.Pos(offset)
+	path, _ := astutil.PathEnclosingInterval(a.File, pos, pos)
+	if len(path) == 0 {
+		return nil, nil
+	}
+	id, ok := path[0].(*ast.Ident)
+	if !ok {
+		return nil, nil
+	}
+	return id, a.Info.ObjectOf(id)
+}
+
+// Definition returns where the identifier at a byte offset of the
+// document is declared, or nil for builtins and unresolved names.
+// Declarations in the standard library come with their file.
+func Definition(ctx context.Context, tc Toolchain, src []byte, offset int) (*Location, error) {
+	a, err := Analyze(ctx, tc, src)
+	if err != nil {
+		return nil, err
+	}
+	_, obj := a.identAt(offset)
+	if obj == nil || !obj.Pos().IsValid() {
+		return nil, nil
+	}
+
+	pos := a.Fset.Position(obj.Pos())
+	if pos.Filename == progFile {
+		start := a.Offset(obj.Pos())
+		l := location(src, start, start+len(obj.Name()))
+		return &l, nil
+	}
+	if !strings.HasPrefix(pos.Filename, "$GOROOT/") {
+		return nil, nil
+	}
+
+	file := strings.TrimPrefix(pos.Filename, "$GOROOT/")
+	data, err := ioutil.ReadFile(filepath.Join(tc.root(), filepath.FromSlash(file)))
+	if err != nil {
+		return nil, err
+	}
+	// Export data only has lines and columns.
+	lines := strings.SplitAfter(string(data), "\n")
+	if pos.Line < 1 || pos.Line > len(lines) {
+		return nil, nil
+	}
+	start := len(strings.Join(lines[:pos.Line-1], ""))
+	if col := pos.Column - 1; col > 0 && col < len(lines[pos.Line-1]) {
+		start += col
+	} else if i := strings.Index(lines[pos.Line-1], obj.Name()); i >= 0 {
+		start += i
+	}
+	l := location(data, start, start+len(obj.Name()))
+	l.File, l.Source = file, string(data)
+	return &l, nil
+}
+
+// References returns the uses and the declaration in the document of
+// the object the identifier at a byte offset denotes, in order.
+func References(ctx context.Context, tc Toolchain, src []byte, offset int) ([]Location, error) {
+	a, err := Analyze(ctx, tc, src)
+	if err != nil {
+		return nil, err
+	}
+	_, obj := a.identAt(offset)
+	if obj == nil {
+		return nil, nil
+	}
+
+	var ids []*ast.Ident
+	for id, o := range a.Info.Defs {
+		if o == obj {
+			ids = append(ids, id)
+		}
+	}
+	for id, o := range a.Info.Uses {
+		if o == obj {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Pos() < ids[j].Pos() })
+
+	var locs []Location
+	for _, id := range ids {
+		start := a.Offset(id.Pos())
+		locs = append(locs, location(src, start, start+len(id.Name)))
+	}
+	return locs, nil
+}
+
+// Outline returns the types, functions, methods, constants and
+// variables declared at the top of the document, in order.
+func Outline(ctx context.Context, tc Toolchain, src []byte) ([]Symbol, error) {
+	a, err := Analyze(ctx, tc, src)
+	if err != nil {
+		return nil, err
+	}
+
+	var syms []Symbol
+	add := func(id *ast.Ident, kind, recv string, n ast.Node) {
+		if id.Name == "_" {
+			return
+		}
+		s := Symbol{
+			Name:     id.Name,
+			Kind:     kind,
+			Recv:     recv,
+			Location: location(src, a.Offset(n.Pos()), a.Offset(n.End())),
+		}
+		if obj := a.Info.Defs[id]; obj != nil {
+			if kind == "type" {
+				s.Type = typeKind(obj.Type())
+			} else {
+				s.Type = types.TypeString(obj.Type(), a.qualifier)
+			}
+		}
+		syms = append(syms, s)
+	}
+
+	for _, decl := range a.File.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil || len(d.Recv.List) == 0 {
+				add(d.Name, "func", "", d)
+			} else {
+				add(d.Name, "method", recvName(d.Recv.List[0].Type), d)
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					add(s.Name, "type", "", s)
+				case *ast.ValueSpec:
+					for _, id := range s.Names {
+						add(id, d.Tok.String(), "", s)
+					}
+				}
+			}
+		}
+	}
+	return syms, nil
+}
+
+// recvName returns the name of a receiver type, without pointer or type
+// parameters.
+func recvName(x ast.Expr) string {
+	for {
+		switch t := x.(type) {
+		case *ast.StarExpr:
+			x = t.X
+		case *ast.ParenExpr:
+			x = t.X
+		case *ast.IndexExpr:
+			x = t.X
+		case *ast.IndexListExpr:
+			x = t.X
+		case *ast.Ident:
+			return t.Name
+		default:
+			return types.ExprString(x)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package lib
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestNavigate(t *testing.T) {
+	src := `package main
+
+import "fmt"
+
+const greeting = "héllo"
+
+type Point struct{ X, Y int }
+
+func (p *Point) Move(dx int) { p.X += dx }
+
+var origin, _ = Point{}, 0
+
+func main() {
+	p := Point{X: 1}
+	p.Move(2)
+	fmt.Println(greeting, p.X)
+}
+`
+	offset := func(marker string, after int) int {
+		i := strings.Index(src, marker)
+		if i < 0 {
+			t.Fatalf("no %q in source", marker)
+		}
+		return i + after
+	}
+
+	l, err := Definition(context.Background(), Toolchain{}, []byte(src), offset("p.Move", 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l == nil || l.File != "" || l.Line != 9 || l.Column != 17 || l.EndColumn != 21 {
+		t.Errorf("definition of Move: %+v", l)
+	}
+
+	l, err = Definition(context.Background(), Toolchain{}, []byte(src), offset("Println", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l == nil || l.File != "src/fmt/print.go" {
+		t.Fatalf("definition of fmt.Println: %+v", l)
+	}
+	line := strings.Split(l.Source, "\n")[l.Line-1]
+	if !strings.HasPrefix(line, "func Println(") || l.Column != 6 {
+		t.Errorf("definition of fmt.Println at %d:%d: %q", l.Line, l.Column, line)
+	}
+
+	refs, err := References(context.Background(), Toolchain{}, []byte(src), offset("p.X)", 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	for _, r := range refs {
+		got = append(got, r.Line)
+	}
+	// The declaration, the key of the literal, p.X in Move and in main.
+	if want := []int{7, 9, 14, 16}; !equalInts(got, want) {
+		t.Errorf("references of X on lines %v, want %v", got, want)
+	}
+
+	refs, err = References(context.Background(), Toolchain{}, []byte(src), offset("greeting =", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 || refs[1].Line != 16 || refs[1].Column != 14 {
+		t.Errorf("references of greeting: %+v", refs)
+	}
+
+	syms, err := Outline(context.Background(), Toolchain{}, []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, s := range syms {
+		names = append(names, s.Kind+" "+s.Recv+"."+s.Name+" "+s.Type)
+	}
+	want := []string{
+		"const .greeting untyped string",
+		"type .Point struct",
+		"method Point.Move func(dx int)",
+		"var .origin Point",
+		"func .main func()",
+	}
+	if strings.Join(names, "\n") != strings.Join(want, "\n") {
+		t.Errorf("outline:\n%s\nwant:\n%s", strings.Join(names, "\n"), strings.Join(want, "\n"))
+	}
+	if syms[2].Line != 9 || syms[2].EndLine != 9 {
+		t.Errorf("Move spans lines %d to %d", syms[2].Line, syms[2].EndLine)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
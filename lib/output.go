@@ -0,0 +1,346 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Segment is a piece of program output of one Kind: "text", "image" or
+// "html". Text is the text, styled by ANSI escape sequences, or the
+// sanitized HTML. Images are base64 encoded in Data.
+type Segment struct {
+	Kind  string
+	Text  string     `json:",omitempty"`
+	Style *TextStyle `json:",omitempty"`
+	MIME  string     `json:",omitempty"`
+	Data  string     `json:",omitempty"`
+}
+
+// TextStyle is the style set by ANSI SGR escape sequences. Colors are
+// CSS colors.
+type TextStyle struct {
+	Color      string `json:",omitempty"`
+	Background string `json:",omitempty"`
+	Bold       bool   `json:",omitempty"`
+	Faint      bool   `json:",omitempty"`
+	Italic     bool   `json:",omitempty"`
+	Underline  bool   `json:",omitempty"`
+}
+
+const (
+	// imagePrefix starts a line of base64 encoded PNG, JPEG or GIF
+	// data, like on play.golang.org.
+	imagePrefix = "IMAGE:"
+	// htmlPrefix starts a line of HTML, if the run configuration
+	// allows it.
+	htmlPrefix = "HTML:"
+	// maxPendingOutput bounds the output held back, longer lines are
+	// passed on as text.
+	maxPendingOutput = 4 << 20
+)
+
+var ansiColors = []string{"black", "#c23621", "#25bc24", "#adad27", "#492ee1", "#d338d3", "#33bbc8", "#cbcccd"}
+var ansiBrightColors = []string{"#818383", "#fc391f", "#31e722", "#eaec23", "#5833ff", "#f935f8", "#14f0f0", "white"}
+
+// OutputParser splits a stream of program output into segments. Lines
+// that may be images or HTML are held back until they are complete,
+// other text is passed on as it arrives.
+type OutputParser struct {
+	// HTML enables lines starting with "HTML:".
+	HTML bool
+
+	style   TextStyle
+	pending []byte
+	// plain is set while the rest of a line that was too long to hold
+	// back is passed on as text.
+	plain bool
+}
+
+// ParseOutput parses the complete output of a program.
+func ParseOutput(data []byte, html bool) []Segment {
+	p := &OutputParser{HTML: html}
+	return append(p.Write(data), p.Flush()...)
+}
+
+// Write parses the next part of the output.
+func (p *OutputParser) Write(data []byte) []Segment {
+	p.pending = append(p.pending, data...)
+
+	var segs []Segment
+	for {
+		i := bytes.IndexByte(p.pending, '\n')
+		if i < 0 {
+			break
+		}
+		if p.plain {
+			segs = p.text(segs, p.pending[:i+1])
+			p.plain = false
+		} else {
+			segs = p.line(segs, p.pending[:i+1])
+		}
+		p.pending = p.pending[i+1:]
+	}
+
+	if len(p.pending) > maxPendingOutput {
+		p.plain = true
+	}
+	if len(p.pending) == 0 || !p.plain && p.special(p.pending) {
+		return segs
+	}
+	// Keep an incomplete escape sequence for the next write.
+	n := len(p.pending)
+	if i := bytes.LastIndexByte(p.pending, 0x1b); i >= 0 && !ansiRe.Match(p.pending[i:]) {
+		n = i
+	}
+	segs = p.text(segs, p.pending[:n])
+	p.pending = p.pending[n:]
+	return segs
+}
+
+// Flush returns the output held back at the end of the stream.
+func (p *OutputParser) Flush() []Segment {
+	segs := p.line(nil, p.pending)
+	p.pending = nil
+	return segs
+}
+
+// special reports whether line starts, or could start, like an image
+// or HTML line.
+func (p *OutputParser) special(line []byte) bool {
+	prefixes := []string{imagePrefix}
+	if p.HTML {
+		prefixes = append(prefixes, htmlPrefix)
+	}
+	for _, prefix := range prefixes {
+		if bytes.HasPrefix(line, []byte(prefix)) || strings.HasPrefix(prefix, string(line)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *OutputParser) line(segs []Segment, line []byte) []Segment {
+	if len(line) == 0 {
+		return segs
+	}
+	s := string(line)
+	switch {
+	case strings.HasPrefix(s, imagePrefix):
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s[len(imagePrefix):]))
+		if err != nil {
+			break
+		}
+		switch mime := http.DetectContentType(data); mime {
+		case "image/png", "image/jpeg", "image/gif":
+			return append(segs, Segment{Kind: "image", MIME: mime, Data: base64.StdEncoding.EncodeToString(data)})
+		}
+	case p.HTML && strings.HasPrefix(s, htmlPrefix):
+		return append(segs, Segment{Kind: "html", Text: SanitizeHTML(s[len(htmlPrefix):])})
+	}
+	return p.text(segs, line)
+}
+
+var ansiRe = regexp.MustCompile(`\x1b\[([0-9;?]*)([@-~])`)
+
+// text adds the segments of text styled by its escape sequences.
+func (p *OutputParser) text(segs []Segment, text []byte) []Segment {
+	add := func(s []byte) {
+		if len(s) == 0 {
+			return
+		}
+		var style *TextStyle
+		if p.style != (TextStyle{}) {
+			st := p.style
+			style = &st
+		}
+		if n := len(segs); n > 0 && segs[n-1].Kind == "text" && sameStyle(segs[n-1].Style, style) {
+			segs[n-1].Text += string(s)
+			return
+		}
+		segs = append(segs, Segment{Kind: "text", Text: string(s), Style: style})
+	}
+
+	for len(text) > 0 {
+		loc := ansiRe.FindSubmatchIndex(text)
+		if loc == nil {
+			add(text)
+			break
+		}
+		add(text[:loc[0]])
+		if text[loc[5]-1] == 'm' {
+			p.style.apply(string(text[loc[2]:loc[3]]))
+		}
+		// Other sequences, like cursor movements, are dropped.
+		text = text[loc[1]:]
+	}
+	return segs
+}
+
+func sameStyle(a, b *TextStyle) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// apply applies the parameters of an SGR escape sequence.
+func (s *TextStyle) apply(params string) {
+	var codes []int
+	for _, p := range strings.Split(params, ";") {
+		n, _ := strconv.Atoi(p)
+		codes = append(codes, n)
+	}
+
+	for i := 0; i < len(codes); i++ {
+		switch c := codes[i]; {
+		case c == 0:
+			*s = TextStyle{}
+		case c == 1:
+			s.Bold = true
+		case c == 2:
+			s.Faint = true
+		case c == 3:
+			s.Italic = true
+		case c == 4:
+			s.Underline = true
+		case c == 22:
+			s.Bold, s.Faint = false, false
+		case c == 23:
+			s.Italic = false
+		case c == 24:
+			s.Underline = false
+		case c >= 30 && c <= 37:
+			s.Color = ansiColors[c-30]
+		case c == 39:
+			s.Color = ""
+		case c >= 40 && c <= 47:
+			s.Background = ansiColors[c-40]
+		case c == 49:
+			s.Background = ""
+		case c >= 90 && c <= 97:
+			s.Color = ansiBrightColors[c-90]
+		case c >= 100 && c <= 107:
+			s.Background = ansiBrightColors[c-100]
+		case c == 38 || c == 48:
+			color, n := extendedColor(codes[i+1:])
+			i += n
+			if c == 38 {
+				s.Color = color
+			} else {
+				s.Background = color
+			}
+		}
+	}
+}
+
+// extendedColor parses the 5;n and 2;r;g;b forms of 256 and 24-bit
+// colors. It returns the color and the number of codes used.
+func extendedColor(codes []int) (string, int) {
+	switch {
+	case len(codes) >= 2 && codes[0] == 5:
+		n := codes[1]
+		switch {
+		case n < 8:
+			return ansiColors[n], 2
+		case n < 16:
+			return ansiBrightColors[n-8], 2
+		case n < 232:
+			n -= 16
+			level := func(v int) int {
+				if v == 0 {
+					return 0
+				}
+				return 55 + v*40
+			}
+			return fmt.Sprintf("#%02x%02x%02x", level(n/36), level(n/6%6), level(n%6)), 2
+		case n < 256:
+			g := 8 + (n-232)*10
+			return fmt.Sprintf("#%02x%02x%02x", g, g, g), 2
+		}
+		return "", 2
+	case len(codes) >= 4 && codes[0] == 2:
+		return fmt.Sprintf("#%02x%02x%02x", codes[1]&0xff, codes[2]&0xff, codes[3]&0xff), 4
+	}
+	return "", len(codes)
+}
+
+// htmlTags are the tags SanitizeHTML keeps, with their allowed
+// attributes.
+var htmlTags = map[string][]string{
+	"a": {"href", "title"}, "b": nil, "i": nil, "em": nil, "strong": nil,
+	"code": nil, "pre": nil, "p": nil, "br": nil, "hr": nil, "span": nil,
+	"div": nil, "ul": nil, "ol": nil, "li": nil, "h1": nil, "h2": nil,
+	"h3": nil, "h4": nil, "h5": nil, "h6": nil, "table": nil,
+	"thead": nil, "tbody": nil, "tr": nil, "th": {"colspan", "rowspan"},
+	"td": {"colspan", "rowspan"}, "img": {"src", "alt", "width", "height"},
+	"sub": nil, "sup": nil, "small": nil, "blockquote": nil,
+}
+
+var (
+	htmlTagRe  = regexp.MustCompile(`(?s)<!--.*?-->|<(/?)([a-zA-Z][a-zA-Z0-9]*)((?:[^>"']|"[^"]*"|'[^']*')*)>`)
+	htmlAttrRe = regexp.MustCompile(`([a-zA-Z-]+)(?:\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+))?`)
+	// htmlDropRe matches elements dropped with their content.
+	htmlDropRe = regexp.MustCompile(`(?is)<(script|style|iframe|object|embed|template)\b.*?(?:</\s*(?:script|style|iframe|object|embed|template)\s*>|$)`)
+)
+
+// SanitizeHTML keeps the tags and attributes of s on an allow list and
+// escapes everything else. Links must be http, https or mailto, images
+// data URLs.
+func SanitizeHTML(s string) string {
+	s = htmlDropRe.ReplaceAllString(s, "")
+
+	var b bytes.Buffer
+	for {
+		loc := htmlTagRe.FindStringSubmatchIndex(s)
+		if loc == nil {
+			b.WriteString(html.EscapeString(html.UnescapeString(s)))
+			break
+		}
+		b.WriteString(html.EscapeString(html.UnescapeString(s[:loc[0]])))
+		if loc[4] >= 0 {
+			tag := strings.ToLower(s[loc[4]:loc[5]])
+			if attrs, ok := htmlTags[tag]; ok {
+				if loc[3] > loc[2] {
+					b.WriteString("</" + tag + ">")
+				} else {
+					b.WriteString("<" + tag + sanitizeAttrs(tag, s[loc[6]:loc[7]], attrs) + ">")
+				}
+			}
+		}
+		s = s[loc[1]:]
+	}
+	return b.String()
+}
+
+func sanitizeAttrs(tag, s string, allowed []string) string {
+	var b bytes.Buffer
+	for _, m := range htmlAttrRe.FindAllStringSubmatch(s, -1) {
+		name := strings.ToLower(m[1])
+		ok := false
+		for _, a := range allowed {
+			ok = ok || a == name
+		}
+		if !ok {
+			continue
+		}
+		v := html.UnescapeString(strings.Trim(m[2], `"'`))
+		lower := strings.ToLower(strings.TrimSpace(v))
+		switch {
+		case name == "href" && !(strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "mailto:")):
+			continue
+		case name == "src" && !strings.HasPrefix(lower, "data:image/"):
+			continue
+		}
+		b.WriteString(" " + name + `="` + html.EscapeString(v) + `"`)
+	}
+	if tag == "a" {
+		b.WriteString(` rel="noopener noreferrer" target="_blank"`)
+	}
+	return b.String()
+}
@@ -0,0 +1,75 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+func TestOutputParser(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	img := "IMAGE:" + base64.StdEncoding.EncodeToString(png) + "\n"
+
+	p := &OutputParser{}
+	var segs []Segment
+	for _, chunk := range []string{"plain \x1b[1;3", "1mred\x1b[0m\n", img[:4], img[4:10], img[10:], "HTML:<b>x</b>\n"} {
+		segs = append(segs, p.Write([]byte(chunk))...)
+	}
+	segs = append(segs, p.Flush()...)
+
+	want := []Segment{
+		{Kind: "text", Text: "plain "},
+		{Kind: "text", Text: "red", Style: &TextStyle{Color: "#c23621", Bold: true}},
+		{Kind: "text", Text: "\n"},
+		{Kind: "image", MIME: "image/png", Data: base64.StdEncoding.EncodeToString(png)},
+		{Kind: "text", Text: "HTML:<b>x</b>\n"},
+	}
+	if !reflect.DeepEqual(segs, want) {
+		t.Errorf("got %+v\nwant %+v", segs, want)
+	}
+
+	segs = ParseOutput([]byte("HTML:<b>x</b>\nIMAGE:bm90IGFuIGltYWdl\n"), true)
+	want = []Segment{
+		{Kind: "html", Text: "<b>x</b>\n"},
+		{Kind: "text", Text: "IMAGE:bm90IGFuIGltYWdl\n"},
+	}
+	if !reflect.DeepEqual(segs, want) {
+		t.Errorf("got %+v\nwant %+v", segs, want)
+	}
+}
+
+func TestSanitizeHTML(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{`<b onclick="x()">bold</b>`, `<b>bold</b>`},
+		{`<script>alert(1)</script>ok`, `ok`},
+		{`<a href="javascript:alert(1)">x</a>`, `<a rel="noopener noreferrer" target="_blank">x</a>`},
+		{`<a href='https://go.dev'>go</a>`, `<a href="https://go.dev" rel="noopener noreferrer" target="_blank">go</a>`},
+		{`<img src="http://x/y.png" alt="a">`, `<img alt="a">`},
+		{`<iframe src="x"></iframe><blink>1 &lt; 2</blink>`, `1 &lt; 2`},
+		{`<!-- c --><td colspan=2 style="x">`, `<td colspan="2">`},
+	} {
+		if got := SanitizeHTML(tt.in); got != tt.want {
+			t.Errorf("SanitizeHTML(%q): got %q want %q", tt.in, got, tt.want)
+		}
+	}
+
+}
+
+func TestOutputParserLongLine(t *testing.T) {
+	// A line too long to hold back is passed on as text.
+	p := &OutputParser{}
+	long := "IMAGE:" + string(bytes.Repeat([]byte("A"), maxPendingOutput))
+	var n int
+	for _, chunk := range []string{long, "AAAA", "I\n", "IMAGE:x"} {
+		for _, s := range p.Write([]byte(chunk)) {
+			if s.Kind != "text" {
+				t.Errorf("got a %v segment", s.Kind)
+			}
+			n += len(s.Text)
+		}
+	}
+	if want := len(long) + len("AAAAI\n"); n != want || len(p.pending) != len("IMAGE:x") {
+		t.Errorf("passed on %v bytes, want %v, held back %q", n, want, p.pending)
+	}
+}
@@ -0,0 +1,315 @@
+package lib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"sort"
+)
+
+// ProfileReport is a pprof profile summarized for display: the functions
+// with the highest cost and the call tree for a flame graph.
+type ProfileReport struct {
+	Kind  string
+	Unit  string
+	Total int64
+	Top   []ProfileEntry
+	Tree  *ProfileNode
+}
+
+// ProfileEntry is the cost of a function. Flat is the cost of the
+// function itself, Cum includes the functions it called.
+type ProfileEntry struct {
+	Func string
+	Flat int64
+	Cum  int64
+}
+
+// ProfileNode is a node of the call tree, the root is the entry point
+// of every stack.
+type ProfileNode struct {
+	Func     string
+	Value    int64
+	Children []*ProfileNode
+}
+
+// profile is the part of a pprof profile.proto message needed for
+// reports.
+type profile struct {
+	sampleTypes [][2]int64 // type, unit as string table indexes
+	samples     []profileSample
+	locations   map[uint64][]uint64 // location id to function ids, innermost first
+	functions   map[uint64]int64    // function id to name as string table index
+	strings     []string
+}
+
+type profileSample struct {
+	locations []uint64
+	values    []int64
+}
+
+var errProfile = errors.New("malformed profile")
+
+// ParseProfile reads a pprof profile and reports on the sample type
+// named kind, like "cpu" or "alloc_space". n limits the top table.
+func ParseProfile(data []byte, kind string, n int) (*ProfileReport, error) {
+	if len(data) > 1 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if data, err = ioutil.ReadAll(gz); err != nil {
+			return nil, err
+		}
+	}
+
+	p, err := decodeProfile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	index := -1
+	r := &ProfileReport{Kind: kind, Tree: &ProfileNode{Func: "root"}}
+	for i, st := range p.sampleTypes {
+		if p.str(st[0]) == kind {
+			index = i
+			r.Unit = p.str(st[1])
+		}
+	}
+	if index < 0 {
+		return nil, errors.New("profile has no " + kind + " samples")
+	}
+
+	flat := make(map[string]int64)
+	cum := make(map[string]int64)
+	for _, s := range p.samples {
+		if index >= len(s.values) || s.values[index] == 0 {
+			continue
+		}
+		v := s.values[index]
+		r.Total += v
+
+		stack := p.stack(s)
+		if len(stack) > 0 {
+			flat[stack[0]] += v
+		}
+		seen := make(map[string]bool)
+		for _, fn := range stack {
+			if !seen[fn] {
+				cum[fn] += v
+				seen[fn] = true
+			}
+		}
+
+		node := r.Tree
+		node.Value += v
+		for i := len(stack) - 1; i >= 0; i-- {
+			node = node.child(stack[i])
+			node.Value += v
+		}
+	}
+
+	for fn, c := range cum {
+		r.Top = append(r.Top, ProfileEntry{Func: fn, Flat: flat[fn], Cum: c})
+	}
+	sort.Sort(byFlat(r.Top))
+	if len(r.Top) > n {
+		r.Top = r.Top[:n]
+	}
+	return r, nil
+}
+
+func (n *ProfileNode) child(fn string) *ProfileNode {
+	for _, c := range n.Children {
+		if c.Func == fn {
+			return c
+		}
+	}
+	c := &ProfileNode{Func: fn}
+	n.Children = append(n.Children, c)
+	return c
+}
+
+type byFlat []ProfileEntry
+
+func (s byFlat) Len() int      { return len(s) }
+func (s byFlat) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byFlat) Less(i, j int) bool {
+	if s[i].Flat != s[j].Flat {
+		return s[i].Flat > s[j].Flat
+	}
+	if s[i].Cum != s[j].Cum {
+		return s[i].Cum > s[j].Cum
+	}
+	return s[i].Func < s[j].Func
+}
+
+func (p *profile) str(i int64) string {
+	if i < 0 || int(i) >= len(p.strings) {
+		return ""
+	}
+	return p.strings[i]
+}
+
+// stack returns the function names of a sample, innermost first.
+func (p *profile) stack(s profileSample) []string {
+	var stack []string
+	for _, loc := range s.locations {
+		for _, fn := range p.locations[loc] {
+			stack = append(stack, p.str(p.functions[fn]))
+		}
+	}
+	return stack
+}
+
+// decodeProfile decodes the protocol buffer encoding of a profile.
+func decodeProfile(data []byte) (*profile, error) {
+	p := &profile{
+		locations: make(map[uint64][]uint64),
+		functions: make(map[uint64]int64),
+	}
+
+	err := decodeMessage(data, func(field int, v uint64, b []byte) error {
+		switch field {
+		case 1: // sample_type
+			var st [2]int64
+			err := decodeMessage(b, func(field int, v uint64, b []byte) error {
+				if field == 1 || field == 2 {
+					st[field-1] = int64(v)
+				}
+				return nil
+			})
+			p.sampleTypes = append(p.sampleTypes, st)
+			return err
+		case 2: // sample
+			var s profileSample
+			err := decodeMessage(b, func(field int, v uint64, b []byte) error {
+				switch field {
+				case 1:
+					return decodeRepeated(v, b, func(v uint64) { s.locations = append(s.locations, v) })
+				case 2:
+					return decodeRepeated(v, b, func(v uint64) { s.values = append(s.values, int64(v)) })
+				}
+				return nil
+			})
+			p.samples = append(p.samples, s)
+			return err
+		case 4: // location
+			var id uint64
+			var fns []uint64
+			err := decodeMessage(b, func(field int, v uint64, b []byte) error {
+				switch field {
+				case 1:
+					id = v
+				case 4: // line
+					return decodeMessage(b, func(field int, v uint64, b []byte) error {
+						if field == 1 {
+							fns = append(fns, v)
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+			p.locations[id] = fns
+			return err
+		case 5: // function
+			var id uint64
+			var name int64
+			err := decodeMessage(b, func(field int, v uint64, b []byte) error {
+				switch field {
+				case 1:
+					id = v
+				case 2:
+					name = int64(v)
+				}
+				return nil
+			})
+			p.functions[id] = name
+			return err
+		case 6: // string_table
+			p.strings = append(p.strings, string(b))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// decodeMessage calls fn for every field of a protocol buffer message.
+// Varint fields are passed in v, length-delimited ones in b.
+func decodeMessage(data []byte, fn func(field int, v uint64, b []byte) error) error {
+	for len(data) > 0 {
+		key, n := decodeVarint(data)
+		if n == 0 {
+			return errProfile
+		}
+		data = data[n:]
+
+		var v uint64
+		var b []byte
+		switch key & 7 {
+		case 0:
+			if v, n = decodeVarint(data); n == 0 {
+				return errProfile
+			}
+			data = data[n:]
+		case 1:
+			if len(data) < 8 {
+				return errProfile
+			}
+			data = data[8:]
+		case 2:
+			l, n := decodeVarint(data)
+			if n == 0 || uint64(len(data)-n) < l {
+				return errProfile
+			}
+			b = data[n : n+int(l)]
+			data = data[n+int(l):]
+		case 5:
+			if len(data) < 4 {
+				return errProfile
+			}
+			data = data[4:]
+		default:
+			return errProfile
+		}
+
+		if err := fn(int(key>>3), v, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeRepeated decodes a repeated varint field, which is either a
+// single value v or packed into b.
+func decodeRepeated(v uint64, b []byte, fn func(uint64)) error {
+	if b == nil {
+		fn(v)
+		return nil
+	}
+	for len(b) > 0 {
+		v, n := decodeVarint(b)
+		if n == 0 {
+			return errProfile
+		}
+		fn(v)
+		b = b[n:]
+	}
+	return nil
+}
+
+func decodeVarint(data []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(data) && i < 10; i++ {
+		v |= uint64(data[i]&0x7f) << (7 * uint(i))
+		if data[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
@@ -0,0 +1,44 @@
+package lib
+
+import (
+	"bytes"
+	"runtime"
+	"runtime/pprof"
+	"testing"
+)
+
+var profileSink [][]byte
+
+func TestParseProfile(t *testing.T) {
+	rate := runtime.MemProfileRate
+	runtime.MemProfileRate = 1
+	defer func() { runtime.MemProfileRate = rate }()
+
+	for i := 0; i < 100; i++ {
+		profileSink = append(profileSink, make([]byte, 1024))
+	}
+	runtime.GC()
+
+	var buf bytes.Buffer
+	if err := pprof.Lookup("allocs").WriteTo(&buf, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := ParseProfile(buf.Bytes(), "alloc_space", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Unit != "bytes" {
+		t.Errorf("got %v want bytes", r.Unit)
+	}
+	if r.Total < 100*1024 || r.Tree.Value != r.Total {
+		t.Errorf("got total %v and tree %v want at least %v", r.Total, r.Tree.Value, 100*1024)
+	}
+	if len(r.Top) == 0 || len(r.Top) > 5 {
+		t.Errorf("got %v want 1 to 5 entries", len(r.Top))
+	}
+
+	if _, err := ParseProfile(buf.Bytes(), "cpu", 5); err == nil {
+		t.Errorf("got nil want error for missing sample type")
+	}
+}
@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+)
+
+// PreviewPrefix is the path the HTTP server of the program running in
+// a room is proxied at, followed by the name of the room.
+const PreviewPrefix = "/preview/"
+
+// freePort returns a local TCP port nothing listens on.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// PreviewURL returns the path the program running in r is previewed
+// at.
+func PreviewURL(r *Room) string {
+	return PreviewPrefix + r.Name + "/"
+}
+
+// previewCSP puts previewed pages in a sandbox with an origin of their
+// own, so that their scripts can't act as gogala.
+const previewCSP = "sandbox allow-scripts"
+
+// PreviewHandler proxies PreviewURL(r) to the port the program running
+// in r was given in $PORT. Responses are sandboxed with previewCSP.
+func PreviewHandler(r *Room) http.Handler {
+	prefix := strings.TrimSuffix(PreviewURL(r), "/")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != prefix && !strings.HasPrefix(req.URL.Path, prefix+"/") {
+			http.NotFound(w, req)
+			return
+		}
+		if req.URL.Path == prefix {
+			http.Redirect(w, req, prefix+"/", http.StatusFound)
+			return
+		}
+
+		p := r.Process()
+		if !p.Running() || p.Port() == 0 {
+			http.Error(w, "No program is running", http.StatusBadGateway)
+			return
+		}
+
+		host := "127.0.0.1:" + strconv.Itoa(p.Port())
+		proxy := &httputil.ReverseProxy{
+			Director: func(req *http.Request) {
+				req.URL.Scheme = "http"
+				req.URL.Host = host
+				req.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, prefix), "/")
+				req.URL.RawPath = ""
+				req.Host = host
+				req.Header.Set("X-Forwarded-Prefix", prefix)
+			},
+			ModifyResponse: func(resp *http.Response) error {
+				resp.Header.Set("Content-Security-Policy", previewCSP)
+				return nil
+			},
+			ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+				http.Error(w, "The program is not listening on $PORT: "+err.Error(), http.StatusBadGateway)
+			},
+		}
+		proxy.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreviewHandler(t *testing.T) {
+	r, err := NewRoom("preview", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := PreviewHandler(r)
+
+	for _, tt := range []struct {
+		path string
+		code int
+	}{
+		{"/preview/preview", http.StatusFound},
+		{"/preview/preview/", http.StatusBadGateway},
+		{"/preview/preview/x", http.StatusBadGateway},
+		{"/preview/previews/x", http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.code {
+			t.Errorf("%v: got %v want %v", tt.path, w.Code, tt.code)
+		}
+	}
+}
+
+func TestPreviewSandbox(t *testing.T) {
+	r, err := NewRoom("sandbox", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := []byte(`package main
+
+import (
+	"net/http"
+	"os"
+)
+
+func main() {
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "default-src *")
+		w.Write([]byte("<script>alert(1)</script>"))
+	})
+	http.ListenAndServe("127.0.0.1:"+os.Getenv("PORT"), nil)
+}
+`)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p, err := Start(ctx, Toolchain{}, src, RunConfig{}, func(string, []byte) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetProcess(p)
+	defer r.SetProcess(nil)
+
+	h := PreviewHandler(r)
+	var w *httptest.ResponseRecorder
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/preview/sandbox/", nil))
+		if w.Code == http.StatusOK {
+			break
+		}
+	}
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %v, program not previewed", w.Code)
+	}
+	csp := w.Header().Get("Content-Security-Policy")
+	if !strings.HasPrefix(csp, "sandbox allow-scripts") || strings.Contains(csp, "allow-same-origin") {
+		t.Errorf("got Content-Security-Policy %q", csp)
+	}
+}
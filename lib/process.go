@@ -0,0 +1,303 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxRunTime is how long a program may run before it is killed.
+const MaxRunTime = 5 * time.Minute
+
+const progBinary = "prog"
+
+// OutputFunc receives the output of a process as it is written. stream
+// is "stdout" or "stderr".
+type OutputFunc func(stream string, data []byte)
+
+// Process is a program run by the local execution backend.
+type Process struct {
+	prog  *Program
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	timer *time.Timer
+
+	wg    sync.WaitGroup
+	done  chan struct{}
+	err   error
+	stats RunStats
+	gc    *gcTraceFilter
+	port  int
+}
+
+// BuildError is returned when the program does not build, Output is
+// what the go tool printed.
+type BuildError struct {
+	Output string
+}
+
+func (e *BuildError) Error() string {
+	return e.Output
+}
+
+// Start builds src and starts it with the options of cfg. The output
+// of the build is returned as a *BuildError if it fails. The process
+// is killed once ctx is done.
+func Start(ctx context.Context, tc Toolchain, src []byte, cfg RunConfig, output OutputFunc) (*Process, error) {
+	return start(ctx, tc, src, src, cfg, output, nil)
+}
+
+// StartLive starts src like Start in live mode: it is instrumented and
+// the printed and assigned values are passed to value with their line.
+func StartLive(ctx context.Context, tc Toolchain, src []byte, cfg RunConfig, output OutputFunc, value func(LiveValue)) (*Process, error) {
+	if !tc.AtLeast(liveMinVersion) {
+		return nil, fmt.Errorf("live mode needs Go 1.%d or newer, not %s", liveMinVersion, tc.Version)
+	}
+	live, err := Instrument(src)
+	if err != nil {
+		return nil, err
+	}
+	return start(ctx, tc, src, live, cfg, output, value)
+}
+
+// start runs code, the program built from the document src. If value
+// is set, code is instrumented and the values are read from its third
+// file descriptor.
+func start(ctx context.Context, tc Toolchain, src, code []byte, cfg RunConfig, output OutputFunc, value func(LiveValue)) (*Process, error) {
+	prog, err := NewProgram(tc, code)
+	if err != nil {
+		return nil, err
+	}
+	if value != nil {
+		if err := ioutil.WriteFile(prog.Path(liveFile), []byte(liveHelpers), 0644); err != nil {
+			prog.Remove()
+			return nil, err
+		}
+	}
+
+	build := prog.Go(ctx, append([]string{"build", "-o", progBinary}, cfg.BuildFlags()...)...)
+	build.Env = append(build.Env, cfg.BuildEnv()...)
+	if out, err := build.CombinedOutput(); err != nil {
+		prog.Remove()
+		if len(out) == 0 {
+			return nil, err
+		}
+		return nil, &BuildError{Output: string(out)}
+	}
+
+	p := &Process{
+		prog: prog,
+		cmd:  exec.CommandContext(ctx, prog.Path(progBinary), cfg.Args...),
+		done: make(chan struct{}),
+	}
+	p.cmd.Dir = prog.Dir
+	p.cmd.Env = append(programEnv(os.Environ()), cfg.Env...)
+	p.stats.Source = SourceHash(src)
+
+	// Servers listen on $PORT to be previewed. The port is always ours,
+	// a configured one could point the preview at any local service.
+	if p.port, err = freePort(); err != nil {
+		prog.Remove()
+		return nil, err
+	}
+	p.cmd.Env = append(p.cmd.Env, "PORT="+strconv.Itoa(p.port))
+
+	if !hasEnv(cfg.Env, "GODEBUG") {
+		p.cmd.Env = append(p.cmd.Env, "GODEBUG=gctrace=1")
+		p.gc = &gcTraceFilter{stats: &p.stats}
+	}
+
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		prog.Remove()
+		return nil, err
+	}
+	stderr, err := p.cmd.StderrPipe()
+	if err != nil {
+		prog.Remove()
+		return nil, err
+	}
+	if p.stdin, err = p.cmd.StdinPipe(); err != nil {
+		prog.Remove()
+		return nil, err
+	}
+	var values, w *os.File
+	if value != nil {
+		if values, w, err = os.Pipe(); err != nil {
+			prog.Remove()
+			return nil, err
+		}
+		p.cmd.ExtraFiles = []*os.File{w}
+	}
+
+	p.stats.Started = time.Now()
+	err = p.cmd.Start()
+	if w != nil {
+		w.Close()
+	}
+	if err != nil {
+		if values != nil {
+			values.Close()
+		}
+		prog.Remove()
+		return nil, err
+	}
+	p.timer = time.AfterFunc(MaxRunTime, func() { p.Kill() })
+
+	p.wg.Add(2)
+	go p.copy("stdout", stdout, output)
+	go p.copy("stderr", stderr, output)
+	if values != nil {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			defer values.Close()
+			readLiveValues(values, value)
+		}()
+	}
+	go p.wait()
+
+	return p, nil
+}
+
+func (p *Process) copy(stream string, r io.Reader, output OutputFunc) {
+	defer p.wg.Done()
+
+	gc := p.gc
+	if stream != "stderr" {
+		gc = nil
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			if gc != nil {
+				data = gc.filter(data)
+			}
+			if len(data) > 0 {
+				output(stream, data)
+			}
+		}
+		if err != nil {
+			if gc != nil {
+				if data := gc.flush(); len(data) > 0 {
+					output(stream, data)
+				}
+			}
+			return
+		}
+	}
+}
+
+func (p *Process) wait() {
+	// All output has to be read before Wait closes the pipes.
+	p.wg.Wait()
+	p.err = p.cmd.Wait()
+	p.stats.setProcessState(p.cmd.ProcessState)
+	p.timer.Stop()
+	p.prog.Remove()
+	close(p.done)
+}
+
+// Write sends data to the standard input of the process.
+func (p *Process) Write(data []byte) (int, error) {
+	return p.stdin.Write(data)
+}
+
+// CloseStdin closes the standard input, the process reads EOF.
+func (p *Process) CloseStdin() error {
+	return p.stdin.Close()
+}
+
+func (p *Process) Kill() error {
+	select {
+	case <-p.done:
+		return nil
+	default:
+	}
+	return p.cmd.Process.Kill()
+}
+
+// Running reports whether p is a process that hasn't exited yet.
+func (p *Process) Running() bool {
+	if p == nil {
+		return false
+	}
+	select {
+	case <-p.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// Wait waits for the process to exit and returns its exit error.
+func (p *Process) Wait() error {
+	<-p.done
+	return p.err
+}
+
+// Stats returns the resource usage of the process. It is complete once
+// the process has exited.
+func (p *Process) Stats() RunStats {
+	<-p.done
+	return p.stats
+}
+
+// Done is closed once the process has exited.
+func (p *Process) Done() <-chan struct{} {
+	return p.done
+}
+
+// Port returns the port the process was given to listen on.
+func (p *Process) Port() int {
+	return p.port
+}
+
+func hasEnv(env []string, key string) bool {
+	_, ok := envValue(env, key)
+	return ok
+}
+
+func envValue(env []string, key string) (string, bool) {
+	for _, kv := range env {
+		if strings.HasPrefix(kv, key+"=") {
+			return kv[len(key)+1:], true
+		}
+	}
+	return "", false
+}
+
+// RunOutput runs src without input until it exits, d has passed or ctx
+// is done and returns its combined output.
+func RunOutput(ctx context.Context, tc Toolchain, src []byte, cfg RunConfig, d time.Duration) ([]byte, RunStats, error) {
+	var mu sync.Mutex
+	var out bytes.Buffer
+
+	p, err := Start(ctx, tc, src, cfg, func(stream string, data []byte) {
+		mu.Lock()
+		out.Write(data)
+		mu.Unlock()
+	})
+	if err != nil {
+		return nil, RunStats{}, err
+	}
+	p.CloseStdin()
+
+	t := time.AfterFunc(d, func() { p.Kill() })
+	defer t.Stop()
+
+	err = p.Wait()
+	return out.Bytes(), p.Stats(), err
+}
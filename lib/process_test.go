@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStartContext(t *testing.T) {
+	src := []byte("package main\n\nimport \"time\"\n\nfunc main() { time.Sleep(time.Hour) }\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p, err := Start(ctx, Toolchain{}, src, RunConfig{}, func(string, []byte) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	select {
+	case <-p.Done():
+	case <-time.After(10 * time.Second):
+		p.Kill()
+		t.Fatal("program still running after its context was cancelled")
+	}
+	if p.Wait() == nil {
+		t.Error("killed program exited without error")
+	}
+}
+
+func TestStartEnv(t *testing.T) {
+	t.Setenv("GOGALA_SECRET", "hunter2")
+	src := []byte("package main\n\nimport \"os\"\n\nfunc main() { print(os.Getenv(\"GOGALA_SECRET\"), \"|\", os.Getenv(\"NAME\")) }\n")
+
+	cfg := RunConfig{Env: []string{"NAME=gopher"}}
+	out, _, err := RunOutput(context.Background(), Toolchain{}, src, cfg, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "|gopher" {
+		t.Errorf("got %q, want only the variables of the configuration", out)
+	}
+}
+
+func TestProcessStdin(t *testing.T) {
+	src := []byte(`package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+)
+
+func main() {
+	s := bufio.NewScanner(os.Stdin)
+	for s.Scan() {
+		fmt.Printf("got %s\n", s.Text())
+	}
+	fmt.Println("EOF")
+}
+`)
+	var mu sync.Mutex
+	var out bytes.Buffer
+	p, err := Start(context.Background(), Toolchain{}, src, RunConfig{}, func(stream string, data []byte) {
+		mu.Lock()
+		out.Write(data)
+		mu.Unlock()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.Write([]byte("a\nb\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CloseStdin(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-p.Done():
+	case <-time.After(10 * time.Second):
+		p.Kill()
+		t.Fatal("program still running after its input was closed")
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "got a\ngot b\nEOF\n" {
+		t.Errorf("got output %q", got)
+	}
+}
+
+func TestStartPort(t *testing.T) {
+	src := []byte("package main\n\nimport \"os\"\n\nfunc main() { print(os.Getenv(\"PORT\")) }\n")
+
+	var mu sync.Mutex
+	var out bytes.Buffer
+	cfg := RunConfig{Env: []string{"PORT=8080"}}
+	p, err := Start(context.Background(), Toolchain{}, src, cfg, func(stream string, data []byte) {
+		mu.Lock()
+		out.Write(data)
+		mu.Unlock()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.CloseStdin()
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if p.Port() == 8080 || out.String() != strconv.Itoa(p.Port()) {
+		t.Errorf("program got PORT %q, previewed port %v", out.String(), p.Port())
+	}
+}
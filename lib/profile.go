@@ -0,0 +1,96 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	profileTop      = 20
+	cpuProfile      = "cpu.out"
+	memProfile      = "mem.out"
+	profileMainTest = "gogala_main_test.go"
+)
+
+// Profile runs src with CPU and heap profiling and returns its output
+// and a report for each profile. mode is "bench" to profile the
+// benchmarks of src and "run" to profile its main function. Without a
+// mode, benchmarks are profiled if there are any.
+func Profile(ctx context.Context, tc Toolchain, src []byte, mode string) ([]byte, []*ProfileReport, error) {
+	p, err := NewProgram(tc, src)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer p.Remove()
+
+	args, err := testRunArgs(p, src, mode)
+	if err != nil {
+		return nil, nil, err
+	}
+	args = append([]string{"test", "-cpuprofile=" + cpuProfile, "-memprofile=" + memProfile, "-timeout=30s"}, args...)
+
+	out, _ := p.Go(ctx, args...).CombinedOutput()
+
+	var reports []*ProfileReport
+	for _, prof := range []struct{ file, kind string }{
+		{cpuProfile, "cpu"},
+		{memProfile, "alloc_space"},
+	} {
+		data, err := ioutil.ReadFile(p.Path(prof.file))
+		if err != nil {
+			// The build failed or the program exited from main.
+			continue
+		}
+		r, err := ParseProfile(data, prof.kind, profileTop)
+		if err != nil {
+			return out, reports, err
+		}
+		reports = append(reports, r)
+	}
+	return out, reports, nil
+}
+
+// testRunArgs returns the go test flags running the benchmarks of src
+// for mode "bench" or its main function for mode "run", defaulting to
+// the benchmarks if there are any.
+func testRunArgs(p *Program, src []byte, mode string) ([]string, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), progFile, src, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	hasMain, hasBench := false, false
+	for _, d := range f.Decls {
+		if fn, ok := d.(*ast.FuncDecl); ok && fn.Recv == nil {
+			hasMain = hasMain || fn.Name.Name == "main" && f.Name.Name == "main"
+			hasBench = hasBench || strings.HasPrefix(fn.Name.Name, "Benchmark") && IsTestFunc(fn.Name.Name)
+		}
+	}
+	if mode == "" {
+		mode = "run"
+		if hasBench {
+			mode = "bench"
+		}
+	}
+
+	switch {
+	case mode == "bench" && hasBench:
+		return []string{"-run=^$", "-bench=.", "-benchmem"}, nil
+	case mode == "run" && hasMain:
+		// go test profiles and traces test binaries only, so main is
+		// run from a test.
+		test := "package main\n\nimport \"testing\"\n\nfunc TestGogalaMain(t *testing.T) { main() }\n"
+		if err := ioutil.WriteFile(p.Path(profileMainTest), []byte(test), 0644); err != nil {
+			return nil, err
+		}
+		return []string{"-run=^TestGogalaMain$"}, nil
+	case mode == "bench":
+		return nil, errors.New("no benchmark found")
+	}
+	return nil, errors.New("no main function found")
+}
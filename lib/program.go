@@ -0,0 +1,251 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	progModule   = "prog"
+	progFile     = "prog.go"
+	progTestFile = "prog_test.go"
+)
+
+// Program is the shared document written out as a Go package, so that
+// it can be built, run and tested with the local go tool.
+//
+// Test, Benchmark, Fuzz and Example functions are moved to a _test.go
+// file and everything else to a regular file. Both files keep the line
+// layout of the document, so positions reported by the go tool map
+// straight back to it.
+type Program struct {
+	Dir       string
+	Toolchain Toolchain
+}
+
+func NewProgram(tc Toolchain, src []byte) (*Program, error) {
+	dir, err := ioutil.TempDir("", "gogala")
+	if err != nil {
+		return nil, err
+	}
+	p := &Program{Dir: dir, Toolchain: tc}
+
+	code, tests, err := SplitTests(src)
+	if err != nil {
+		p.Remove()
+		return nil, err
+	}
+
+	files := map[string][]byte{
+		"go.mod": goMod(tc),
+		progFile: code,
+	}
+	if tests != nil {
+		files[progTestFile] = tests
+	}
+
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			p.Remove()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *Program) Remove() error {
+	return os.RemoveAll(p.Dir)
+}
+
+func (p *Program) Path(elem ...string) string {
+	return filepath.Join(append([]string{p.Dir}, elem...)...)
+}
+
+// Go returns a go tool command running inside the program directory,
+// killed once ctx is done. Tests run by it get its environment.
+func (p *Program) Go(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := p.Toolchain.Command(ctx, args...)
+	cmd.Dir = p.Dir
+	cmd.Env = append(programEnv(cmd.Environ()), "GO111MODULE=on", "GOFLAGS=-mod=mod", "GOTOOLCHAIN=local")
+	return cmd
+}
+
+// programEnvKeys are the variables of the server's environment that
+// programs and the go tool building them get. The rest may hold secrets.
+var programEnvKeys = []string{
+	"PATH", "HOME", "TMPDIR", "LANG", "TZ",
+	"GOROOT", "GOPATH", "GOCACHE", "GOMODCACHE", "GOPROXY",
+}
+
+// programEnv returns the variables of env that programs may see.
+func programEnv(env []string) []string {
+	var out []string
+	for _, key := range programEnvKeys {
+		// The last value of a key is the one in effect.
+		for i := len(env) - 1; i >= 0; i-- {
+			if strings.HasPrefix(env[i], key+"=") {
+				out = append(out, env[i])
+				break
+			}
+		}
+	}
+	return out
+}
+
+func goMod(tc Toolchain) []byte {
+	var b bytes.Buffer
+	b.WriteString("module " + progModule + "\n")
+
+	if v := tc.LanguageVersion(); v != "" {
+		b.WriteString("\ngo " + v + "\n")
+	}
+	return b.Bytes()
+}
+
+// SplitTests splits src into the regular and the test part of a
+// package. Declarations that belong to the other file are blanked out
+// and imports it does not use are renamed to "_". tests is nil when src
+// declares no test functions.
+func SplitTests(src []byte) (code, tests []byte, err error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, progFile, src, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var codeDecls, testDecls []ast.Decl
+	for _, d := range f.Decls {
+		if g, ok := d.(*ast.GenDecl); ok && g.Tok == token.IMPORT {
+			continue
+		}
+		if fn, ok := d.(*ast.FuncDecl); ok && fn.Recv == nil && IsTestFunc(fn.Name.Name) {
+			testDecls = append(testDecls, d)
+		} else {
+			codeDecls = append(codeDecls, d)
+		}
+	}
+
+	code = keepDecls(fset, f, src, testDecls, codeDecls)
+	if len(testDecls) > 0 {
+		tests = keepDecls(fset, f, src, codeDecls, testDecls)
+	}
+	return code, tests, nil
+}
+
+// IsTestFunc reports whether name is picked up by go test.
+func IsTestFunc(name string) bool {
+	for _, prefix := range []string{"Test", "Benchmark", "Fuzz", "Example"} {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		rest := name[len(prefix):]
+		if rest == "" || prefix == "Example" && rest[0] == '_' {
+			return true
+		}
+		r, _ := utf8.DecodeRuneInString(rest)
+		return !unicode.IsLower(r)
+	}
+	return false
+}
+
+func keepDecls(fset *token.FileSet, f *ast.File, src []byte, drop, keep []ast.Decl) []byte {
+	out := make([]byte, len(src))
+	copy(out, src)
+
+	offset := func(p token.Pos) int { return fset.Position(p).Offset }
+
+	for _, d := range drop {
+		for i := offset(d.Pos()); i < offset(d.End()); i++ {
+			if out[i] != '\n' {
+				out[i] = ' '
+			}
+		}
+	}
+
+	used := make(map[string]bool)
+	for _, d := range keep {
+		ast.Inspect(d, func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok {
+				if id, ok := sel.X.(*ast.Ident); ok && id.Obj == nil {
+					used[id.Name] = true
+				}
+			}
+			return true
+		})
+	}
+
+	// Walk imports backwards so that inserted bytes don't shift the
+	// offsets of the ones still to be visited.
+	for i := len(f.Imports) - 1; i >= 0; i-- {
+		spec := f.Imports[i]
+		name := importName(spec)
+		if name == "_" || name == "." || name == "C" || used[name] {
+			continue
+		}
+		if spec.Name != nil {
+			start, end := offset(spec.Name.Pos()), offset(spec.Name.End())
+			out[start] = '_'
+			for j := start + 1; j < end; j++ {
+				out[j] = ' '
+			}
+			continue
+		}
+		at := offset(spec.Path.Pos())
+		out = append(out[:at], append([]byte("_ "), out[at:]...)...)
+	}
+	return out
+}
+
+var diagnosticRe = regexp.MustCompile(`^(?:\./)?prog(?:_test)?\.go:(\d+):(\d+): (.*)$`)
+
+// ParseDiagnostics returns the messages about the program files in the
+// output of the go tool.
+func ParseDiagnostics(out []byte) []Diagnostic {
+	var diags []Diagnostic
+	for _, line := range strings.Split(string(out), "\n") {
+		m := diagnosticRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		l, _ := strconv.Atoi(m[1])
+		c, _ := strconv.Atoi(m[2])
+		diags = append(diags, Diagnostic{Line: l, Col: c, Message: m[3]})
+	}
+	return diags
+}
+
+func importName(spec *ast.ImportSpec) string {
+	if spec.Name != nil {
+		return spec.Name.Name
+	}
+	p, _ := strconv.Unquote(spec.Path.Value)
+	if p == "C" {
+		return p
+	}
+	name := path.Base(p)
+	if isMajorVersion(name) {
+		name = path.Base(path.Dir(p))
+	}
+	return name
+}
+
+func isMajorVersion(s string) bool {
+	if len(s) < 2 || s[0] != 'v' {
+		return false
+	}
+	_, err := strconv.Atoi(s[1:])
+	return err == nil
+}
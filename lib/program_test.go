@@ -0,0 +1,78 @@
+package lib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSplitTests(t *testing.T) {
+	src := []byte(`package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func main() {
+	fmt.Println("hi")
+}
+
+func TestMain(t *testing.T) {
+	t.Log("hi")
+}
+`)
+	code, tests, err := SplitTests(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, b := range [][]byte{code, tests} {
+		if a, b := bytes.Count(b, []byte("\n")), bytes.Count(src, []byte("\n")); a != b {
+			t.Errorf("got %v lines want %v", a, b)
+		}
+	}
+
+	if strings.Contains(string(code), "TestMain") || !strings.Contains(string(code), `_ "testing"`) {
+		t.Errorf("unexpected code file:\n%s", code)
+	}
+	if strings.Contains(string(tests), "func main") || !strings.Contains(string(tests), `_ "fmt"`) {
+		t.Errorf("unexpected test file:\n%s", tests)
+	}
+}
+
+func TestIsTestFunc(t *testing.T) {
+	for name, want := range map[string]bool{
+		"Test":          true,
+		"TestFoo":       true,
+		"Testify":       false,
+		"BenchmarkX":    true,
+		"FuzzParse":     true,
+		"Example":       true,
+		"Example_types": true,
+		"Examples":      false,
+		"main":          false,
+	} {
+		if got := IsTestFunc(name); got != want {
+			t.Errorf("IsTestFunc(%q) = %v want %v", name, got, want)
+		}
+	}
+}
+
+func TestParseDiagnostics(t *testing.T) {
+	out := []byte("# prog\n./prog.go:3:27: undefined: x\nprog_test.go:10:2: declared and not used: y\nFAIL\tprog [build failed]\n")
+
+	diags := ParseDiagnostics(out)
+	want := []Diagnostic{
+		{Line: 3, Col: 27, Message: "undefined: x"},
+		{Line: 10, Col: 2, Message: "declared and not used: y"},
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("got %v want %v", diags, want)
+	}
+	for i := range want {
+		if diags[i] != want[i] {
+			t.Errorf("got %v want %v", diags[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Room holds the state shared by everybody editing the document.
+type Room struct {
+	sync.Mutex
+
+	Name string
+	// Dir keeps data that outlives a single run, like the fuzz corpus.
+	Dir string
+
+	process   *Process
+	private   map[string]*Process
+	owner     string
+	sharing   Sharing
+	format    FormatOptions
+	configs   map[string]RunConfig
+	toolchain Toolchain
+	stats     []RunStats
+	history   []RunRecord
+	lastRun   int
+	autoRun   AutoRun
+	auto      autoRunner
+	traces    map[string]string
+
+	// corpus guards the fuzz corpus in Dir, which sessions running
+	// at the same time load and save.
+	corpus sync.Mutex
+}
+
+// maxRunStats is the number of runs a room keeps the stats of.
+const maxRunStats = 20
+
+func NewRoom(name, dir string) (*Room, error) {
+	r := &Room{
+		Name: name,
+		Dir:  filepath.Join(dir, name),
+		configs: map[string]RunConfig{
+			DefaultRunConfig: {Name: DefaultRunConfig},
+		},
+		autoRun: AutoRun{
+			Delay:  int(DefaultAutoRunDelay / time.Millisecond),
+			Config: DefaultRunConfig,
+		},
+		private: make(map[string]*Process),
+		traces:  make(map[string]string),
+		sharing: Sharing{Scope: ScopeRoom},
+		format:  DefaultFormatOptions,
+	}
+	if err := os.MkdirAll(r.Dir, 0755); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Room) Path(elem ...string) string {
+	return filepath.Join(append([]string{r.Dir}, elem...)...)
+}
+
+// Process returns the program running in the room, if any.
+func (r *Room) Process() *Process {
+	r.Lock()
+	defer r.Unlock()
+	return r.process
+}
+
+// SetProcess makes p the program running in the room, killing the one
+// that ran before. A nil p only kills it.
+func (r *Room) SetProcess(p *Process) {
+	r.Lock()
+	defer r.Unlock()
+	if r.process != nil {
+		r.process.Kill()
+	}
+	r.process = p
+}
+
+// PrivateProcess returns the program user runs privately, if any.
+func (r *Room) PrivateProcess(user string) *Process {
+	r.Lock()
+	defer r.Unlock()
+	return r.private[user]
+}
+
+// SetPrivateProcess makes p the program user runs privately, killing
+// the one user ran before. A nil p only kills it.
+func (r *Room) SetPrivateProcess(user string, p *Process) {
+	r.Lock()
+	defer r.Unlock()
+	if old := r.private[user]; old != nil {
+		old.Kill()
+	}
+	if p == nil {
+		delete(r.private, user)
+		return
+	}
+	r.private[user] = p
+}
+
+// RunConfig returns the named run configuration, or the default one if
+// there is no such configuration.
+func (r *Room) RunConfig(name string) RunConfig {
+	r.Lock()
+	defer r.Unlock()
+	if c, ok := r.configs[name]; ok {
+		return c
+	}
+	return r.configs[DefaultRunConfig]
+}
+
+func (r *Room) SetRunConfig(c RunConfig) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.configs[c.Name] = c
+	return nil
+}
+
+// RunConfigs returns the run configurations sorted by name.
+func (r *Room) RunConfigs() []RunConfig {
+	r.Lock()
+	defer r.Unlock()
+	configs := make([]RunConfig, 0, len(r.configs))
+	for _, c := range r.configs {
+		configs = append(configs, c)
+	}
+	sort.Sort(byConfigName(configs))
+	return configs
+}
+
+type byConfigName []RunConfig
+
+func (s byConfigName) Len() int           { return len(s) }
+func (s byConfigName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byConfigName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+// Toolchain returns the Go toolchain the room builds with.
+func (r *Room) Toolchain() Toolchain {
+	r.Lock()
+	defer r.Unlock()
+	return r.toolchain
+}
+
+func (r *Room) SetToolchain(tc Toolchain) {
+	r.Lock()
+	defer r.Unlock()
+	r.toolchain = tc
+}
+
+// AddRunStats records the stats of a finished run.
+func (r *Room) AddRunStats(s RunStats) {
+	r.Lock()
+	defer r.Unlock()
+	r.stats = append(r.stats, s)
+	if len(r.stats) > maxRunStats {
+		r.stats = r.stats[len(r.stats)-maxRunStats:]
+	}
+}
+
+// RunStats returns the stats of the last runs, oldest first.
+func (r *Room) RunStats() []RunStats {
+	r.Lock()
+	defer r.Unlock()
+	return append([]RunStats(nil), r.stats...)
+}
@@ -0,0 +1,71 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+)
+
+const DefaultRunConfig = "default"
+
+// RunConfig is a named set of options for running the document, shared
+// by everybody in the room.
+type RunConfig struct {
+	Name string
+	// Args are the command-line arguments of the program.
+	Args []string
+	// Env holds extra KEY=value environment variables.
+	Env          []string
+	Tags         []string
+	GCFlags      string
+	LDFlags      string
+	GOEXPERIMENT string
+	// HTML shows output lines starting with "HTML:" as sanitized HTML.
+	HTML bool
+}
+
+func (c RunConfig) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("run configuration without a name")
+	}
+	for _, kv := range c.Env {
+		if i := strings.Index(kv, "="); i <= 0 {
+			return fmt.Errorf("bad environment variable %q, want KEY=value", kv)
+		}
+	}
+	for _, t := range c.Tags {
+		if t == "" || strings.ContainsAny(t, ", \t") {
+			return fmt.Errorf("bad build tag %q", t)
+		}
+	}
+	return nil
+}
+
+// NeedsLocal reports whether the configuration sets options that only
+// the local execution backend supports, all but HTML.
+func (c RunConfig) NeedsLocal() bool {
+	return len(c.Args) > 0 || len(c.Env) > 0 || len(c.Tags) > 0 ||
+		c.GCFlags != "" || c.LDFlags != "" || c.GOEXPERIMENT != ""
+}
+
+// BuildFlags returns the go build flags of the configuration.
+func (c RunConfig) BuildFlags() []string {
+	var flags []string
+	if len(c.Tags) > 0 {
+		flags = append(flags, "-tags="+strings.Join(c.Tags, ","))
+	}
+	if c.GCFlags != "" {
+		flags = append(flags, "-gcflags="+c.GCFlags)
+	}
+	if c.LDFlags != "" {
+		flags = append(flags, "-ldflags="+c.LDFlags)
+	}
+	return flags
+}
+
+// BuildEnv returns the environment of the go tool for the configuration.
+func (c RunConfig) BuildEnv() []string {
+	if c.GOEXPERIMENT == "" {
+		return nil
+	}
+	return []string{"GOEXPERIMENT=" + c.GOEXPERIMENT}
+}
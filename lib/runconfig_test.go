@@ -0,0 +1,53 @@
+package lib
+
+import "testing"
+
+func TestRunConfigValidate(t *testing.T) {
+	ok := RunConfig{Name: "verbose", Env: []string{"DEBUG=1"}, Tags: []string{"integration"}}
+	if err := ok.Validate(); err != nil {
+		t.Errorf("got %v want nil", err)
+	}
+
+	for _, c := range []RunConfig{
+		{},
+		{Name: "a", Env: []string{"=1"}},
+		{Name: "a", Env: []string{"DEBUG"}},
+		{Name: "a", Tags: []string{"a,b"}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("%+v: got nil want error", c)
+		}
+	}
+}
+
+func TestRunConfigBuildFlags(t *testing.T) {
+	c := RunConfig{Tags: []string{"a", "b"}, GCFlags: "-N -l", LDFlags: "-s"}
+	got := c.BuildFlags()
+	want := []string{"-tags=a,b", "-gcflags=-N -l", "-ldflags=-s"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %v want %v", got[i], want[i])
+		}
+	}
+}
+
+func TestRunConfigNeedsLocal(t *testing.T) {
+	if (RunConfig{Name: "html", HTML: true}).NeedsLocal() {
+		t.Error("HTML output needs no local run")
+	}
+	for _, c := range []RunConfig{
+		{Args: []string{"-v"}},
+		{Env: []string{"DEBUG=1"}},
+		{Tags: []string{"integration"}},
+		{GCFlags: "-N -l"},
+		{LDFlags: "-s"},
+		{GOEXPERIMENT: "rangefunc"},
+	} {
+		if !c.NeedsLocal() {
+			t.Errorf("%+v: needs a local run", c)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package lib
+
+import "os"
+
+func maxRSS(ps *os.ProcessState) int64 {
+	return 0
+}
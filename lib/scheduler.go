@@ -0,0 +1,349 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// MaxQueuedJobs is the number of jobs a user may have waiting in the
+// queue of a scheduler.
+const MaxQueuedJobs = 5
+
+var ErrQueueFull = errors.New("too many jobs queued, wait for them to finish")
+
+// Job is a run, test or other use of the go tool scheduled for a user
+// of a room.
+type Job struct {
+	Room string
+	User string
+	// Kind is the action of the job. A job still queued is replaced by
+	// a newer one of the same user and kind.
+	Kind string
+	// Timeout overrides the timeout of the scheduler.
+	Timeout time.Duration
+	// Run does the work, it has to return once ctx is done.
+	Run func(ctx context.Context)
+	// Position is called with the place of the job in the queue, 1
+	// being next, 0 once it runs and -1 if it was cancelled before.
+	Position func(n int)
+	// Failed is called if Run panics, the panic is logged and doesn't
+	// take the other jobs down.
+	Failed func(err error)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	pos    int
+}
+
+// Scheduler runs jobs on a bounded number of workers. Rooms take turns
+// when picking the next job, and so do the users of a room, so that
+// nobody waits behind the whole queue of somebody else.
+type Scheduler struct {
+	Workers int
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	queue   jobQueue
+	running map[*Job]bool
+}
+
+func NewScheduler(workers int, timeout time.Duration) *Scheduler {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Scheduler{
+		Workers: workers,
+		Timeout: timeout,
+		running: make(map[*Job]bool),
+	}
+}
+
+// Submit queues j and starts it as soon as a worker is free.
+func (s *Scheduler) Submit(j *Job) error {
+	s.mu.Lock()
+	var cancelled []*Job
+	n := 0
+	for _, q := range s.queue.jobs() {
+		switch {
+		case q.Room != j.Room || q.User != j.User:
+		case q.Kind == j.Kind:
+			s.queue.remove(q)
+			cancelled = append(cancelled, q)
+		default:
+			n++
+		}
+	}
+	if n >= MaxQueuedJobs {
+		s.mu.Unlock()
+		notifyCancelled(cancelled)
+		return ErrQueueFull
+	}
+
+	// The timeout starts when the job does, waiting doesn't count.
+	j.ctx, j.cancel = context.WithCancel(context.Background())
+	j.pos = -1
+	s.queue.push(j)
+	notify := s.dispatch()
+	s.mu.Unlock()
+
+	notifyCancelled(cancelled)
+	notify()
+	return nil
+}
+
+// Cancel cancels the queued and running jobs of a user in a room.
+func (s *Scheduler) Cancel(room, user string) {
+	s.mu.Lock()
+	var cancelled []*Job
+	for _, j := range s.queue.jobs() {
+		if j.Room == room && j.User == user {
+			s.queue.remove(j)
+			cancelled = append(cancelled, j)
+		}
+	}
+	for j := range s.running {
+		if j.Room == room && j.User == user {
+			j.cancel()
+		}
+	}
+	notify := s.positions()
+	s.mu.Unlock()
+
+	notifyCancelled(cancelled)
+	notify()
+}
+
+// CancelJob cancels j, whether it is queued or running.
+func (s *Scheduler) CancelJob(j *Job) {
+	s.mu.Lock()
+	var cancelled []*Job
+	for _, q := range s.queue.jobs() {
+		if q == j {
+			s.queue.remove(j)
+			cancelled = append(cancelled, j)
+		}
+	}
+	if s.running[j] {
+		j.cancel()
+	}
+	notify := s.positions()
+	s.mu.Unlock()
+
+	notifyCancelled(cancelled)
+	notify()
+}
+
+// Len returns the number of queued and running jobs.
+func (s *Scheduler) Len() (queued, running int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queue.jobs()), len(s.running)
+}
+
+// dispatch starts queued jobs on the free workers. It returns a
+// function sending the new queue positions, to be called without the
+// lock held.
+func (s *Scheduler) dispatch() func() {
+	for len(s.running) < s.Workers {
+		j := s.queue.pop()
+		if j == nil {
+			break
+		}
+		s.running[j] = true
+		go s.run(j)
+	}
+	return s.positions()
+}
+
+func (s *Scheduler) run(j *Job) {
+	defer func() {
+		j.cancel()
+		s.mu.Lock()
+		delete(s.running, j)
+		notify := s.dispatch()
+		s.mu.Unlock()
+		notify()
+	}()
+	defer func() {
+		if v := recover(); v != nil {
+			log.Printf("job %s of %s in %s panicked: %v\n%s", j.Kind, j.User, j.Room, v, debug.Stack())
+			if j.Failed != nil {
+				j.Failed(fmt.Errorf("%s failed: internal error", j.Kind))
+			}
+		}
+	}()
+	timeout := j.Timeout
+	if timeout == 0 {
+		timeout = s.Timeout
+	}
+	ctx, cancel := context.WithTimeout(j.ctx, timeout)
+	defer cancel()
+	j.Run(ctx)
+}
+
+// positions updates the positions of the queued and running jobs.
+func (s *Scheduler) positions() func() {
+	var changed []*Job
+	for j := range s.running {
+		if j.pos != 0 {
+			j.pos = 0
+			changed = append(changed, j)
+		}
+	}
+
+	// The order jobs will run in is found by picking from a copy.
+	q := s.queue.clone()
+	for n := 1; ; n++ {
+		j := q.pop()
+		if j == nil {
+			break
+		}
+		if j.pos != n {
+			j.pos = n
+			changed = append(changed, j)
+		}
+	}
+
+	positions := make([]int, len(changed))
+	for i, j := range changed {
+		positions[i] = j.pos
+	}
+	return func() {
+		for i, j := range changed {
+			if j.Position != nil {
+				j.Position(positions[i])
+			}
+		}
+	}
+}
+
+func notifyCancelled(jobs []*Job) {
+	for _, j := range jobs {
+		j.cancel()
+		if j.Position != nil {
+			j.Position(-1)
+		}
+	}
+}
+
+// jobQueue holds the waiting jobs by room and user. Both take turns,
+// next is the room to pick from.
+type jobQueue struct {
+	rooms []*roomJobs
+	next  int
+}
+
+type roomJobs struct {
+	name  string
+	users []*userJobs
+	next  int
+}
+
+type userJobs struct {
+	name string
+	jobs []*Job
+}
+
+func (q *jobQueue) push(j *Job) {
+	var r *roomJobs
+	for _, rj := range q.rooms {
+		if rj.name == j.Room {
+			r = rj
+		}
+	}
+	if r == nil {
+		r = &roomJobs{name: j.Room}
+		q.rooms = append(q.rooms, r)
+	}
+
+	var u *userJobs
+	for _, uj := range r.users {
+		if uj.name == j.User {
+			u = uj
+		}
+	}
+	if u == nil {
+		u = &userJobs{name: j.User}
+		r.users = append(r.users, u)
+	}
+	u.jobs = append(u.jobs, j)
+}
+
+// pop removes and returns the next job, nil if there is none.
+func (q *jobQueue) pop() *Job {
+	if len(q.rooms) == 0 {
+		return nil
+	}
+	q.next %= len(q.rooms)
+	r := q.rooms[q.next]
+	r.next %= len(r.users)
+	u := r.users[r.next]
+
+	j := u.jobs[0]
+	u.jobs = u.jobs[1:]
+	if len(u.jobs) == 0 {
+		r.users = append(r.users[:r.next], r.users[r.next+1:]...)
+	} else {
+		r.next++
+	}
+	if len(r.users) == 0 {
+		q.rooms = append(q.rooms[:q.next], q.rooms[q.next+1:]...)
+	} else {
+		q.next++
+	}
+	return j
+}
+
+func (q *jobQueue) remove(j *Job) {
+	for ri, r := range q.rooms {
+		for ui, u := range r.users {
+			for i, uj := range u.jobs {
+				if uj != j {
+					continue
+				}
+				u.jobs = append(u.jobs[:i], u.jobs[i+1:]...)
+				if len(u.jobs) == 0 {
+					r.users = append(r.users[:ui], r.users[ui+1:]...)
+					if ui < r.next {
+						r.next--
+					}
+				}
+				if len(r.users) == 0 {
+					q.rooms = append(q.rooms[:ri], q.rooms[ri+1:]...)
+					if ri < q.next {
+						q.next--
+					}
+				}
+				return
+			}
+		}
+	}
+}
+
+// jobs returns the queued jobs in no particular order.
+func (q *jobQueue) jobs() []*Job {
+	var jobs []*Job
+	for _, r := range q.rooms {
+		for _, u := range r.users {
+			jobs = append(jobs, u.jobs...)
+		}
+	}
+	return jobs
+}
+
+func (q *jobQueue) clone() *jobQueue {
+	c := &jobQueue{next: q.next}
+	for _, r := range q.rooms {
+		rc := &roomJobs{name: r.name, next: r.next}
+		for _, u := range r.users {
+			rc.users = append(rc.users, &userJobs{name: u.name, jobs: append([]*Job(nil), u.jobs...)})
+		}
+		c.rooms = append(c.rooms, rc)
+	}
+	return c
+}
@@ -0,0 +1,212 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	s := NewScheduler(1, time.Second)
+
+	var mu sync.Mutex
+	var order []string
+	positions := make(map[string]int)
+	done := make(chan bool)
+	block := make(chan bool)
+
+	job := func(room, user, kind string) *Job {
+		name := room + "/" + user + "/" + kind
+		return &Job{
+			Room: room,
+			User: user,
+			Kind: kind,
+			Run: func(ctx context.Context) {
+				mu.Lock()
+				order = append(order, name)
+				mu.Unlock()
+				if kind == "block" {
+					<-block
+				}
+				done <- true
+			},
+			Position: func(n int) {
+				mu.Lock()
+				if _, ok := positions[name]; !ok || n != 0 {
+					positions[name] = n
+				}
+				mu.Unlock()
+			},
+		}
+	}
+
+	s.Submit(job("r", "a", "block"))
+	for _, j := range []*Job{
+		job("r", "a", "run"),
+		job("r", "a", "test"),
+		job("r", "a", "vet"),
+		job("r", "b", "run"),
+		job("s", "c", "run"),
+		job("r", "a", "vet"),
+	} {
+		if err := s.Submit(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mu.Lock()
+	want := map[string]int{
+		"r/a/block": 0,
+		"r/a/run":   1,
+		"s/c/run":   2,
+		"r/b/run":   3,
+		"r/a/test":  4,
+		"r/a/vet":   5,
+	}
+	for name, n := range want {
+		if positions[name] != n {
+			t.Errorf("%v: got position %v want %v", name, positions[name], n)
+		}
+	}
+	mu.Unlock()
+
+	close(block)
+	for i := 0; i < 6; i++ {
+		<-done
+	}
+	wantOrder := []string{"r/a/block", "r/a/run", "s/c/run", "r/b/run", "r/a/test", "r/a/vet"}
+	for i, name := range wantOrder {
+		if i >= len(order) || order[i] != name {
+			t.Fatalf("got order %v want %v", order, wantOrder)
+		}
+	}
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	s := NewScheduler(1, 50*time.Millisecond)
+
+	errs := make(chan error, 2)
+	wait := func(ctx context.Context) {
+		<-ctx.Done()
+		errs <- ctx.Err()
+	}
+	cancelled := make(chan int, 1)
+	s.Submit(&Job{Room: "r", User: "a", Kind: "run", Run: wait})
+	s.Submit(&Job{Room: "r", User: "b", Kind: "run", Run: wait, Position: func(n int) {
+		if n < 0 {
+			cancelled <- n
+		}
+	}})
+
+	s.Cancel("r", "b")
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("queued job was not cancelled")
+	}
+
+	if err := <-errs; err != context.DeadlineExceeded {
+		t.Errorf("got %v want a timeout", err)
+	}
+	if queued, running := s.Len(); queued != 0 || running > 1 {
+		t.Errorf("got %v queued and %v running jobs", queued, running)
+	}
+}
+
+func TestSchedulerCancelJob(t *testing.T) {
+	s := NewScheduler(1, time.Minute)
+
+	errs := make(chan error, 1)
+	running := &Job{Room: "r", User: "a", Kind: "run", Run: func(ctx context.Context) {
+		<-ctx.Done()
+		errs <- ctx.Err()
+	}}
+	cancelled := make(chan bool, 1)
+	queued := &Job{Room: "r", User: "b", Kind: "run", Run: func(ctx context.Context) {}, Position: func(n int) {
+		if n < 0 {
+			cancelled <- true
+		}
+	}}
+	s.Submit(running)
+	s.Submit(queued)
+
+	s.CancelJob(queued)
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("queued job was not cancelled")
+	}
+	s.CancelJob(running)
+	select {
+	case err := <-errs:
+		if err != context.Canceled {
+			t.Errorf("got %v want cancelled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("running job was not cancelled")
+	}
+}
+
+func TestSchedulerPanic(t *testing.T) {
+	s := NewScheduler(1, time.Second)
+
+	failed := make(chan error, 1)
+	s.Submit(&Job{
+		Room:   "r",
+		User:   "a",
+		Kind:   "run",
+		Run:    func(ctx context.Context) { panic("boom") },
+		Failed: func(err error) { failed <- err },
+	})
+	select {
+	case err := <-failed:
+		if err == nil {
+			t.Error("got a nil error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("panic not reported")
+	}
+
+	// The worker is free again.
+	done := make(chan bool)
+	s.Submit(&Job{Room: "r", User: "a", Kind: "run", Run: func(ctx context.Context) { done <- true }})
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("job after the panic did not run")
+	}
+}
+
+func TestSchedulerTimeoutStartsWithJob(t *testing.T) {
+	s := NewScheduler(1, 200*time.Millisecond)
+
+	slow := &Job{Room: "r", User: "a", Kind: "slow", Timeout: time.Second, Run: func(ctx context.Context) {
+		time.Sleep(300 * time.Millisecond)
+	}}
+	errs := make(chan error, 1)
+	queued := &Job{Room: "r", User: "b", Kind: "run", Run: func(ctx context.Context) {
+		deadline, _ := ctx.Deadline()
+		if ctx.Err() != nil || time.Until(deadline) < 100*time.Millisecond {
+			errs <- fmt.Errorf("started with %v left, err %v", time.Until(deadline), ctx.Err())
+			return
+		}
+		errs <- nil
+	}}
+	if err := s.Submit(slow); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Submit(queued); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued job never ran")
+	}
+}
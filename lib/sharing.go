@@ -0,0 +1,112 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The scopes of the results of an action: only the user who asked for
+// it, or everybody in the room.
+const (
+	ScopePrivate = "private"
+	ScopeRoom    = "room"
+)
+
+var ErrNotOwner = errors.New("only the owner of the room can change who shares results")
+
+// Sharing are the settings of a room for the results of actions. Scope
+// is used for actions that don't ask for one. When Restricted, only the
+// owner of the room and the Allowed users may share results with the
+// room, like starting the program everybody sees.
+type Sharing struct {
+	Scope      string
+	Restricted bool
+	Allowed    []string `json:",omitempty"`
+}
+
+func (s Sharing) Validate() error {
+	if s.Scope != ScopePrivate && s.Scope != ScopeRoom {
+		return fmt.Errorf("unknown scope %q, want %q or %q", s.Scope, ScopePrivate, ScopeRoom)
+	}
+	return nil
+}
+
+// Owner returns the name of the user owning the room, "" if nobody
+// does.
+func (r *Room) Owner() string {
+	r.Lock()
+	defer r.Unlock()
+	return r.owner
+}
+
+func (r *Room) SetOwner(user string) {
+	r.Lock()
+	defer r.Unlock()
+	r.owner = user
+}
+
+// Sharing returns the sharing settings of the room.
+func (r *Room) Sharing() Sharing {
+	r.Lock()
+	defer r.Unlock()
+	return r.sharing
+}
+
+// SetSharing changes the sharing settings on behalf of user, who has to
+// own the room.
+func (r *Room) SetSharing(user string, s Sharing) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	r.Lock()
+	defer r.Unlock()
+	if user != r.owner {
+		return ErrNotOwner
+	}
+	r.sharing = s
+	return nil
+}
+
+// MayShare reports whether user may share results with the room.
+func (r *Room) MayShare(user string) bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.mayShare(user)
+}
+
+func (r *Room) mayShare(user string) bool {
+	if !r.sharing.Restricted || user == r.owner {
+		return true
+	}
+	for _, u := range r.sharing.Allowed {
+		if u == user {
+			return true
+		}
+	}
+	return false
+}
+
+// Shares reports whether the results of an action user asked for in
+// scope go to the room. An empty scope is the default of the room,
+// which falls back to private for users who may not share.
+func (r *Room) Shares(user, scope string) (bool, error) {
+	r.Lock()
+	defer r.Unlock()
+	explicit := scope != ""
+	if !explicit {
+		scope = r.sharing.Scope
+	}
+	switch scope {
+	case ScopePrivate:
+		return false, nil
+	case ScopeRoom:
+		if r.mayShare(user) {
+			return true, nil
+		}
+		if explicit {
+			return false, errors.New("the owner of the room restricted who shares results, ask them or run privately")
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("unknown scope %q", scope)
+}
@@ -0,0 +1,43 @@
+package lib
+
+import "testing"
+
+func TestSharing(t *testing.T) {
+	r, err := NewRoom("sharing", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetOwner("U-00")
+
+	shares := func(user, scope string) bool {
+		share, err := r.Shares(user, scope)
+		if err != nil {
+			t.Fatalf("%s %q: %v", user, scope, err)
+		}
+		return share
+	}
+
+	if !shares("U-01", "") || shares("U-01", ScopePrivate) || !shares("U-01", ScopeRoom) {
+		t.Error("rooms should share by default")
+	}
+
+	if err := r.SetSharing("U-01", Sharing{Scope: ScopePrivate}); err != ErrNotOwner {
+		t.Errorf("got %v, want ErrNotOwner", err)
+	}
+	if err := r.SetSharing("U-00", Sharing{Scope: "everybody"}); err == nil {
+		t.Error("unknown scope accepted")
+	}
+	if err := r.SetSharing("U-00", Sharing{Scope: ScopeRoom, Restricted: true, Allowed: []string{"U-02"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !shares("U-00", "") || !shares("U-02", "") {
+		t.Error("owner and allowed users should share")
+	}
+	if shares("U-01", "") {
+		t.Error("restricted user should fall back to private")
+	}
+	if _, err := r.Shares("U-01", ScopeRoom); err == nil {
+		t.Error("restricted user shared")
+	}
+}
@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// simplify applies the rewrites of gofmt -s to f:
+//
+//	[]T{T{}, T{}}    to []T{{}, {}}
+//	[]*T{&T{}}       to []*T{{}}
+//	s[a:len(s)]      to s[a:]
+//	for x, _ = range to for x = range
+//	for _ = range    to for range
+func simplify(f *ast.File) {
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CompositeLit:
+			simplifyCompositeLit(n)
+
+		case *ast.SliceExpr:
+			// s[a:len(s)] is only simplified for a variable s, as the
+			// expression might have side effects.
+			s, ok := n.X.(*ast.Ident)
+			if !ok || n.Slice3 || s.Obj == nil {
+				break
+			}
+			call, ok := n.High.(*ast.CallExpr)
+			if !ok || len(call.Args) != 1 || call.Ellipsis.IsValid() {
+				break
+			}
+			if fun, ok := call.Fun.(*ast.Ident); !ok || fun.Name != "len" || fun.Obj != nil {
+				break
+			}
+			if arg, ok := call.Args[0].(*ast.Ident); ok && arg.Obj == s.Obj {
+				n.High = nil
+			}
+
+		case *ast.RangeStmt:
+			if isBlank(n.Value) {
+				n.Value = nil
+			}
+			if isBlank(n.Key) && n.Value == nil && n.Tok == token.ASSIGN {
+				n.Key = nil
+			}
+		}
+		return true
+	})
+}
+
+func isBlank(x ast.Expr) bool {
+	id, ok := x.(*ast.Ident)
+	return ok && id.Name == "_"
+}
+
+// simplifyCompositeLit drops the types of elements of an array, slice
+// or map literal that repeat the element type.
+func simplifyCompositeLit(lit *ast.CompositeLit) {
+	var keyType, eltType ast.Expr
+	switch t := lit.Type.(type) {
+	case *ast.ArrayType:
+		eltType = t.Elt
+	case *ast.MapType:
+		keyType, eltType = t.Key, t.Value
+	default:
+		return
+	}
+
+	for i, x := range lit.Elts {
+		if kv, ok := x.(*ast.KeyValueExpr); ok {
+			if keyType != nil {
+				kv.Key = simplifyElement(kv.Key, keyType)
+			}
+			kv.Value = simplifyElement(kv.Value, eltType)
+			continue
+		}
+		if keyType == nil {
+			lit.Elts[i] = simplifyElement(x, eltType)
+		}
+	}
+}
+
+// simplifyElement returns x without its type if typ is implied.
+func simplifyElement(x, typ ast.Expr) ast.Expr {
+	if lit, ok := x.(*ast.CompositeLit); ok && lit.Type != nil && sameExpr(lit.Type, typ) {
+		lit.Type = nil
+		return lit
+	}
+	if addr, ok := x.(*ast.UnaryExpr); ok && addr.Op == token.AND {
+		ptr, ok := typ.(*ast.StarExpr)
+		lit, isLit := addr.X.(*ast.CompositeLit)
+		if ok && isLit && lit.Type != nil && sameExpr(lit.Type, ptr.X) {
+			lit.Type = nil
+			return lit
+		}
+	}
+	return x
+}
+
+func sameExpr(a, b ast.Expr) bool {
+	return types.ExprString(a) == types.ExprString(b)
+}
@@ -0,0 +1,142 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// RunStats is the resource usage of a run, fields that could not be
+// measured are zero. The GC summary is read from GODEBUG=gctrace=1,
+// Goroutines is only counted by traced runs.
+type RunStats struct {
+	Source   string
+	Started  time.Time
+	Wall     time.Duration
+	User     time.Duration
+	System   time.Duration
+	MaxRSS   int64
+	ExitCode int
+
+	NumGC    int
+	GCPause  time.Duration
+	PeakHeap int64
+
+	Goroutines int
+}
+
+// SourceHash identifies a version of the document.
+func SourceHash(src []byte) string {
+	h := sha1.Sum(src)
+	return hex.EncodeToString(h[:])[:12]
+}
+
+// PlaygroundStats returns the stats of a run of src on the playground
+// started at started. The playground only returns the output, so the
+// wall time includes the build and the round trip.
+func PlaygroundStats(src []byte, started time.Time, exitCode int) RunStats {
+	return RunStats{
+		Source:   SourceHash(src),
+		Started:  started,
+		Wall:     time.Since(started),
+		ExitCode: exitCode,
+	}
+}
+
+// TraceStats returns the stats of a traced run of src started at
+// started. go test runs it, so only the wall time and the goroutines of
+// the trace are known.
+func TraceStats(src []byte, started time.Time, report *TraceReport) RunStats {
+	return RunStats{
+		Source:     SourceHash(src),
+		Started:    started,
+		Wall:       time.Since(started),
+		Goroutines: len(report.Goroutines),
+	}
+}
+
+// gc 1 @0.001s 14%: 0.014+0.50+0.004 ms clock, ..., 3->4->3 MB, ...
+var gcTraceRe = regexp.MustCompile(`^gc (\d+) @\S+ \d+%: ([\d.]+)\+[\d.]+\+([\d.]+) ms clock, .*?(\d+)->(\d+)->\d+ MB`)
+
+// addGCTrace adds a gctrace line to the stats. It reports false if
+// line is not a gctrace line.
+func (s *RunStats) addGCTrace(line []byte) bool {
+	m := gcTraceRe.FindSubmatch(line)
+	if m == nil {
+		return false
+	}
+	n, _ := strconv.Atoi(string(m[1]))
+	if n > s.NumGC {
+		s.NumGC = n
+	}
+	for _, ms := range [][]byte{m[2], m[3]} {
+		f, _ := strconv.ParseFloat(string(ms), 64)
+		s.GCPause += time.Duration(f * float64(time.Millisecond))
+	}
+	// The heap at the start and at the end of the cycle, the last
+	// number is what was live after it.
+	for _, mb := range [][]byte{m[4], m[5]} {
+		if heap, _ := strconv.ParseInt(string(mb), 10, 64); heap<<20 > s.PeakHeap {
+			s.PeakHeap = heap << 20
+		}
+	}
+	return true
+}
+
+// setProcessState fills in the stats known once the process exited.
+func (s *RunStats) setProcessState(ps *os.ProcessState) {
+	s.Wall = time.Since(s.Started)
+	if ps == nil {
+		return
+	}
+	s.User = ps.UserTime()
+	s.System = ps.SystemTime()
+	s.MaxRSS = maxRSS(ps)
+	s.ExitCode = ps.ExitCode()
+}
+
+// gcTraceFilter takes the gctrace lines out of a stderr stream. Other
+// output is passed on as soon as it can't be the start of such a line.
+type gcTraceFilter struct {
+	stats   *RunStats
+	pending []byte
+}
+
+var gcTracePrefix = []byte("gc ")
+
+func (f *gcTraceFilter) filter(data []byte) []byte {
+	f.pending = append(f.pending, data...)
+
+	var out []byte
+	for {
+		i := bytes.IndexByte(f.pending, '\n')
+		if i < 0 {
+			break
+		}
+		line := f.pending[:i+1]
+		if !f.stats.addGCTrace(line) {
+			out = append(out, line...)
+		}
+		f.pending = f.pending[i+1:]
+	}
+
+	if !bytes.HasPrefix(f.pending, gcTracePrefix) && !bytes.HasPrefix(gcTracePrefix, f.pending) {
+		out = append(out, f.pending...)
+		f.pending = nil
+	}
+	return out
+}
+
+// flush returns output held back at the end of the stream.
+func (f *gcTraceFilter) flush() []byte {
+	out := f.pending
+	f.pending = nil
+	if f.stats.addGCTrace(out) {
+		return nil
+	}
+	return out
+}
@@ -0,0 +1,34 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGCTraceFilter(t *testing.T) {
+	var s RunStats
+	f := &gcTraceFilter{stats: &s}
+
+	var out []byte
+	for _, chunk := range []string{
+		"oops\ngc 1 @0.001s 14%: 0.5+0.50+0.5 ms clock, 0.014+0.31/0/0+0.004 ms cpu, 3->4->3 MB, 4 MB goal, 1 P\ng",
+		"c 2 @0.002s 15%: 1.0+0.42+1.0 ms clock, 0.006+0.13/0/0+0.001 ms cpu, 6->7->7 MB, 7 MB goal, 1 P\n",
+		"prompt> ",
+	} {
+		out = append(out, f.filter([]byte(chunk))...)
+	}
+	out = append(out, f.flush()...)
+
+	if string(out) != "oops\nprompt> " {
+		t.Errorf("got %q want %q", out, "oops\nprompt> ")
+	}
+	if s.NumGC != 2 {
+		t.Errorf("got %v want 2 GCs", s.NumGC)
+	}
+	if s.GCPause != 3*time.Millisecond {
+		t.Errorf("got %v want 3ms", s.GCPause)
+	}
+	if s.PeakHeap != 7<<20 {
+		t.Errorf("got %v want 7MB", s.PeakHeap)
+	}
+}
@@ -0,0 +1,91 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Toolchain is a locally installed Go distribution. The zero Toolchain
+// is the go command found in $PATH.
+type Toolchain struct {
+	Version string
+	GOROOT  string
+}
+
+// LoadToolchains looks up the version of the toolchain installed in each
+// of goroots. Without goroots it returns the toolchain found in $PATH.
+func LoadToolchains(goroots []string) ([]Toolchain, error) {
+	if len(goroots) == 0 {
+		goroots = []string{""}
+	}
+
+	var tcs []Toolchain
+	for _, root := range goroots {
+		tc := Toolchain{GOROOT: root}
+		out, err := tc.Command(context.Background(), "env", "GOVERSION").Output()
+		if err != nil {
+			return nil, fmt.Errorf("no go toolchain in %q: %v", root, err)
+		}
+		tc.Version = strings.TrimSpace(string(out))
+		tcs = append(tcs, tc)
+	}
+	return tcs, nil
+}
+
+// FindToolchain returns the toolchain of tcs with the given version.
+func FindToolchain(tcs []Toolchain, version string) (Toolchain, bool) {
+	for _, tc := range tcs {
+		if tc.Version == version {
+			return tc, true
+		}
+	}
+	return Toolchain{}, false
+}
+
+// Command returns a command running the go tool of the toolchain. It
+// is killed once ctx is done.
+func (tc Toolchain) Command(ctx context.Context, args ...string) *exec.Cmd {
+	name := "go"
+	if tc.GOROOT != "" {
+		name = filepath.Join(tc.GOROOT, "bin", "go")
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	if tc.GOROOT != "" {
+		cmd.Env = append(cmd.Environ(), "GOROOT="+tc.GOROOT)
+	}
+	return cmd
+}
+
+// LanguageVersion returns the language version of the toolchain as used
+// by the go directive of a go.mod file, like "1.22".
+func (tc Toolchain) LanguageVersion() string {
+	v := strings.TrimPrefix(tc.Version, "go")
+	parts := strings.SplitN(v, ".", 3)
+	if len(parts) < 2 || parts[0] != "1" {
+		return ""
+	}
+	// Drop suffixes like "rc1" of pre-release versions.
+	minor := parts[1]
+	if i := strings.IndexFunc(minor, func(r rune) bool { return r < '0' || r > '9' }); i >= 0 {
+		minor = minor[:i]
+	}
+	if minor == "" {
+		return ""
+	}
+	return "1." + minor
+}
+
+// AtLeast reports whether the toolchain is Go 1.minor or newer.
+// Development versions are assumed to be.
+func (tc Toolchain) AtLeast(minor int) bool {
+	v := tc.LanguageVersion()
+	if v == "" {
+		return true
+	}
+	n, _ := strconv.Atoi(strings.TrimPrefix(v, "1."))
+	return n >= minor
+}
@@ -0,0 +1,31 @@
+package lib
+
+import "testing"
+
+func TestLanguageVersion(t *testing.T) {
+	for v, want := range map[string]string{
+		"go1.22.5":    "1.22",
+		"go1.21rc2":   "1.21",
+		"go1.4":       "1.4",
+		"devel +abcd": "",
+		"":            "",
+	} {
+		if got := (Toolchain{Version: v}).LanguageVersion(); got != want {
+			t.Errorf("LanguageVersion(%q) = %q want %q", v, got, want)
+		}
+	}
+}
+
+func TestAtLeast(t *testing.T) {
+	for v, want := range map[string]bool{
+		"go1.18":      true,
+		"go1.22.5":    true,
+		"go1.17.13":   false,
+		"go1.4":       false,
+		"devel +abcd": true,
+	} {
+		if got := (Toolchain{Version: v}).AtLeast(18); got != want {
+			t.Errorf("%q AtLeast(18) = %v want %v", v, got, want)
+		}
+	}
+}
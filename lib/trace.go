@@ -0,0 +1,367 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	traceFile = "trace.out"
+	// maxTraceEvents limits the goroutines, blocking events and
+	// scheduling slices of a report, longer traces are truncated.
+	maxTraceEvents = 5000
+)
+
+// TraceReport is an execution trace summarized for display. Times are
+// in nanoseconds since the start of the trace.
+type TraceReport struct {
+	Duration   int64
+	Goroutines []*GoroutineSpan
+	Blocks     []*TraceBlock
+	Procs      []*ProcTimeline
+	Truncated  bool
+}
+
+// GoroutineSpan is the lifetime of a goroutine and the time it spent in
+// each state. Start is 0 for goroutines created before the trace
+// started, End is 0 for goroutines still alive when it stopped.
+type GoroutineSpan struct {
+	ID       int64
+	Func     string
+	Start    int64
+	End      int64
+	Running  int64
+	Runnable int64
+	Waiting  int64
+	Syscall  int64
+}
+
+// TraceBlock is a goroutine blocking, like on a channel or a mutex.
+// Line is the document line it blocked at, if any. End is 0 if the
+// goroutine was still blocked when the trace stopped.
+type TraceBlock struct {
+	Goroutine int64
+	Start     int64
+	End       int64
+	Reason    string
+	Line      int
+}
+
+// ProcTimeline is what a P, a processor of the scheduler, ran.
+type ProcTimeline struct {
+	ID     int
+	Slices []*ProcSlice
+}
+
+// ProcSlice is a goroutine running on a P.
+type ProcSlice struct {
+	Goroutine int64
+	Start     int64
+	End       int64
+}
+
+// Trace runs src with the execution tracer like Profile and returns
+// its output and the summarized trace. The raw trace is kept in the
+// room at TracePath under name for download.
+func Trace(ctx context.Context, tc Toolchain, r *Room, name string, src []byte, mode string) ([]byte, *TraceReport, error) {
+	p, err := NewProgram(tc, src)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer p.Remove()
+
+	args, err := testRunArgs(p, src, mode)
+	if err != nil {
+		return nil, nil, err
+	}
+	args = append([]string{"test", "-trace=" + traceFile, "-timeout=30s"}, args...)
+
+	out, _ := p.Go(ctx, args...).CombinedOutput()
+
+	data, err := ioutil.ReadFile(p.Path(traceFile))
+	if err != nil {
+		// The build failed.
+		return out, nil, nil
+	}
+	file := TracePath(r, name)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return out, nil, err
+	}
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		return out, nil, err
+	}
+
+	// The parsed trace is read as it is printed, and the tool stopped
+	// once the report is full.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmd := tc.Command(ctx, "tool", "trace", "-d=parsed", p.Path(traceFile))
+	parsed, err := cmd.StdoutPipe()
+	if err != nil {
+		return out, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return out, nil, errors.New("cannot parse trace: " + err.Error())
+	}
+	report := ParseTrace(parsed)
+	if report.Truncated {
+		cancel()
+	}
+	if err := cmd.Wait(); err != nil && !report.Truncated {
+		return out, nil, errors.New("cannot parse trace: " + err.Error())
+	}
+	return out, report, nil
+}
+
+// SharedTrace is the name of the last trace shared with the room.
+const SharedTrace = "room"
+
+// privateTraceRe matches the names of private traces.
+var privateTraceRe = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// TracePath returns the file the last trace kept under name is in, ""
+// if name is not the name of a trace.
+func TracePath(r *Room, name string) string {
+	if name != SharedTrace && !privateTraceRe.MatchString(name) {
+		return ""
+	}
+	return r.Path("traces", name+".out")
+}
+
+// TraceName returns the name the trace of a run by user is kept under:
+// SharedTrace for shared runs, or else a random name of the user that
+// only they are told, so that others can't download it.
+func (r *Room) TraceName(user string, share bool) string {
+	if share {
+		return SharedTrace
+	}
+	r.Lock()
+	defer r.Unlock()
+	if name, ok := r.traces[user]; ok {
+		return name
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	r.traces[user] = hex.EncodeToString(b)
+	return r.traces[user]
+}
+
+var (
+	traceEventRe      = regexp.MustCompile(`^M=-?\d+ P=(-?\d+) G=-?\d+ (\w+) Time=(\d+)(.*)$`)
+	traceTransitionRe = regexp.MustCompile(`^ (GoID|ProcID)=(-?\d+) (\w+)->(\w+) Reason="(.*)"`)
+	traceFrameRe      = regexp.MustCompile(`^\t\t(.*):(\d+)$`)
+	traceFuncRe       = regexp.MustCompile(`^\t(\S+) @ 0x[0-9a-f]+$`)
+)
+
+// traceEvent is a goroutine state transition of the parsed trace.
+type traceEvent struct {
+	p        int
+	time     int64
+	goid     int64
+	from, to string
+	reason   string
+	// funcs and lines are the transition stack, innermost first. lines
+	// are document lines, 0 for frames outside the document.
+	funcs []string
+	lines []int
+}
+
+// goState tracks a goroutine while the trace is summarized.
+type goState struct {
+	span  *GoroutineSpan
+	state string
+	since int64
+	block *TraceBlock
+	slice *ProcSlice
+}
+
+// ParseTrace summarizes the output of go tool trace -d=parsed. It stops
+// reading once the report is truncated.
+func ParseTrace(parsed io.Reader) *TraceReport {
+	t := &traceSummary{
+		report: &TraceReport{},
+		gs:     make(map[int64]*goState),
+		procs:  make(map[int]*ProcTimeline),
+		start:  -1,
+	}
+
+	var ev *traceEvent
+	inStack := false
+	s := bufio.NewScanner(parsed)
+	s.Buffer(nil, 1<<20)
+	for !t.report.Truncated && s.Scan() {
+		line := s.Text()
+		if m := traceEventRe.FindStringSubmatch(line); m != nil {
+			if ev != nil {
+				t.add(ev)
+			}
+			ev, inStack = nil, false
+
+			tm, _ := strconv.ParseInt(m[3], 10, 64)
+			if t.start < 0 {
+				t.start = tm
+			}
+			t.end = tm
+
+			tr := traceTransitionRe.FindStringSubmatch(m[4])
+			if m[2] != "StateTransition" || tr == nil || tr[1] != "GoID" {
+				continue
+			}
+			ev = &traceEvent{time: tm - t.start, from: tr[3], to: tr[4], reason: tr[5]}
+			ev.p, _ = strconv.Atoi(m[1])
+			ev.goid, _ = strconv.ParseInt(tr[2], 10, 64)
+			continue
+		}
+		if ev == nil {
+			continue
+		}
+		switch {
+		case line == "TransitionStack=":
+			inStack = true
+		case strings.HasSuffix(line, "="):
+			// Stack= is where the transition was caused, which is
+			// not the goroutine changing state for wakeups.
+			inStack = false
+		case !inStack:
+		case traceFuncRe.MatchString(line):
+			ev.funcs = append(ev.funcs, traceFuncRe.FindStringSubmatch(line)[1])
+			ev.lines = append(ev.lines, 0)
+		default:
+			if m := traceFrameRe.FindStringSubmatch(line); m != nil && len(ev.lines) > 0 {
+				if f := path.Base(m[1]); f == progFile || f == progTestFile {
+					ev.lines[len(ev.lines)-1], _ = strconv.Atoi(m[2])
+				}
+			}
+		}
+	}
+	if ev != nil {
+		t.add(ev)
+	}
+	t.finish()
+	return t.report
+}
+
+type traceSummary struct {
+	report     *TraceReport
+	gs         map[int64]*goState
+	procs      map[int]*ProcTimeline
+	start, end int64
+	events     int
+}
+
+func (t *traceSummary) add(ev *traceEvent) {
+	g := t.gs[ev.goid]
+	if g == nil {
+		if !t.reserve() {
+			return
+		}
+		g = &goState{span: &GoroutineSpan{ID: ev.goid}}
+		if ev.from == "NotExist" {
+			g.span.Start = ev.time
+		}
+		t.gs[ev.goid] = g
+		t.report.Goroutines = append(t.report.Goroutines, g.span)
+	}
+	if g.span.Func == "" && len(ev.funcs) > 0 {
+		// The outermost frame is the function the goroutine started
+		// with.
+		g.span.Func = ev.funcs[len(ev.funcs)-1]
+	}
+
+	t.account(g, ev.time)
+	g.state = ev.to
+
+	if g.slice != nil && ev.from == "Running" {
+		g.slice.End = ev.time
+		g.slice = nil
+	}
+	if g.block != nil && ev.from == "Waiting" {
+		g.block.End = ev.time
+		g.block = nil
+	}
+
+	switch ev.to {
+	case "Running":
+		if ev.p >= 0 && t.reserve() {
+			g.slice = &ProcSlice{Goroutine: ev.goid, Start: ev.time}
+			pt := t.procs[ev.p]
+			if pt == nil {
+				pt = &ProcTimeline{ID: ev.p}
+				t.procs[ev.p] = pt
+				t.report.Procs = append(t.report.Procs, pt)
+			}
+			pt.Slices = append(pt.Slices, g.slice)
+		}
+	case "Waiting":
+		if ev.from == "Running" && t.reserve() {
+			g.block = &TraceBlock{Goroutine: ev.goid, Start: ev.time, Reason: ev.reason}
+			for _, l := range ev.lines {
+				if l > 0 {
+					g.block.Line = l
+					break
+				}
+			}
+			t.report.Blocks = append(t.report.Blocks, g.block)
+		}
+	case "NotExist":
+		g.span.End = ev.time
+	}
+}
+
+// account adds the time since the last transition of g to the state
+// it was in.
+func (t *traceSummary) account(g *goState, now int64) {
+	d := now - g.since
+	switch g.state {
+	case "Running":
+		g.span.Running += d
+	case "Runnable":
+		g.span.Runnable += d
+	case "Waiting":
+		g.span.Waiting += d
+	case "Syscall":
+		g.span.Syscall += d
+	}
+	g.since = now
+}
+
+// reserve counts an event against maxTraceEvents.
+func (t *traceSummary) reserve() bool {
+	if t.events >= maxTraceEvents {
+		t.report.Truncated = true
+		return false
+	}
+	t.events++
+	return true
+}
+
+func (t *traceSummary) finish() {
+	if t.start >= 0 {
+		t.report.Duration = t.end - t.start
+	}
+	for _, g := range t.gs {
+		t.account(g, t.report.Duration)
+		if g.slice != nil {
+			g.slice.End = t.report.Duration
+		}
+	}
+	sort.Sort(byProcID(t.report.Procs))
+}
+
+type byProcID []*ProcTimeline
+
+func (s byProcID) Len() int           { return len(s) }
+func (s byProcID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byProcID) Less(i, j int) bool { return s[i].ID < s[j].ID }
@@ -0,0 +1,101 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const parsedTrace = `M=-1 P=-1 G=-1 Sync Time=1000 N=1 Trace=1000 Mono=1000 Wall=2026-10-19T09:52:11Z
+M=1 P=0 G=-1 StateTransition Time=1100 GoID=1 Undetermined->Running Reason=""
+M=1 P=0 G=1 StateTransition Time=1200 GoID=7 NotExist->Runnable Reason=""
+TransitionStack=
+	main.main.func1 @ 0x4a0000
+		/tmp/gogala/prog.go:9
+
+Stack=
+	main.main @ 0x4a0100
+		/tmp/gogala/prog.go:8
+
+M=1 P=0 G=1 StateTransition Time=1300 GoID=1 Running->Waiting Reason="chan receive"
+TransitionStack=
+	runtime.chanrecv1 @ 0x400000
+		/usr/local/go/src/runtime/chan.go:506
+	main.main @ 0x4a0120
+		/tmp/gogala/prog.go:12
+
+M=1 P=0 G=-1 StateTransition Time=1400 GoID=7 Runnable->Running Reason=""
+M=1 P=0 G=7 StateTransition Time=1500 GoID=1 Waiting->Runnable Reason=""
+Stack=
+	runtime.chansend1 @ 0x400100
+		/usr/local/go/src/runtime/chan.go:161
+
+M=1 P=0 G=7 StateTransition Time=1600 GoID=7 Running->NotExist Reason=""
+M=1 P=0 G=-1 StateTransition Time=1700 GoID=1 Runnable->Running Reason=""
+M=1 P=0 G=1 Metric Time=2000 Name="/gc/heap/goal:bytes" Value=Value{Uint64(4194304)}
+`
+
+func TestParseTrace(t *testing.T) {
+	r := ParseTrace(strings.NewReader(parsedTrace))
+	if r.Duration != 1000 {
+		t.Errorf("got duration %v want 1000", r.Duration)
+	}
+
+	if len(r.Goroutines) != 2 {
+		t.Fatalf("got %v goroutines want 2", len(r.Goroutines))
+	}
+	want := GoroutineSpan{ID: 7, Func: "main.main.func1", Start: 200, End: 600, Running: 200, Runnable: 200}
+	if g := *r.Goroutines[1]; g != want {
+		t.Errorf("got %+v want %+v", g, want)
+	}
+	if g := r.Goroutines[0]; g.Running != 500 || g.Waiting != 200 || g.Runnable != 200 {
+		t.Errorf("got %+v want 500ns running, 200ns waiting and runnable", *g)
+	}
+
+	if len(r.Blocks) != 1 {
+		t.Fatalf("got %v blocks want 1", len(r.Blocks))
+	}
+	if b := *r.Blocks[0]; b != (TraceBlock{Goroutine: 1, Start: 300, End: 500, Reason: "chan receive", Line: 12}) {
+		t.Errorf("got block %+v", b)
+	}
+
+	if len(r.Procs) != 1 || len(r.Procs[0].Slices) != 3 {
+		t.Fatalf("got procs %+v want 3 slices on one P", r.Procs)
+	}
+	if s := *r.Procs[0].Slices[2]; s != (ProcSlice{Goroutine: 1, Start: 700, End: 1000}) {
+		t.Errorf("got last slice %+v", s)
+	}
+}
+
+func TestParseTraceTruncated(t *testing.T) {
+	var b strings.Builder
+	for i := 0; i < 2*maxTraceEvents; i++ {
+		fmt.Fprintf(&b, "M=1 P=0 G=1 StateTransition Time=%d GoID=%d NotExist->Runnable Reason=\"\"\n", 1000+i, i+2)
+	}
+	r := ParseTrace(strings.NewReader(b.String()))
+	if !r.Truncated || len(r.Goroutines) != maxTraceEvents {
+		t.Errorf("got %v goroutines, truncated %v", len(r.Goroutines), r.Truncated)
+	}
+}
+
+func TestTraceName(t *testing.T) {
+	r, err := NewRoom("trace", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := r.TraceName("a", true); name != SharedTrace {
+		t.Errorf("shared trace named %q", name)
+	}
+	a, b := r.TraceName("a", false), r.TraceName("b", false)
+	if a == b || a != r.TraceName("a", false) {
+		t.Errorf("private traces named %q, %q", a, b)
+	}
+	if TracePath(r, a) == "" || TracePath(r, SharedTrace) == "" {
+		t.Error("no path for a trace name")
+	}
+	for _, name := range []string{"", "../room", "a", "room.out"} {
+		if TracePath(r, name) != "" {
+			t.Errorf("got a path for %q", name)
+		}
+	}
+}
@@ -2,15 +2,30 @@ package lib
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 
 	"golang.org/x/net/websocket"
 )
 
 type Message struct {
-	// in: "format", "edit", "message", "info"
+	// in: "format", "edit", "message", "info", "test", "fuzz", "examples",
+	//     "stdin", "config", "toolchain", "compare", "build-matrix", "asm",
+	//     "optimizations", "vet", "profile", "trace", "live",
+	//     "autorun", "kill", "cancel", "history", "sharing",
+	//     "format-options", "complete", "hover",
+	//     "definition", "references", "outline"
+	// out: "coverage", "failure", "diagnostics", "run", "output", "exit",
+	//      "overlay", "trace", "value", "preview", "queue", "history",
+	//      "history-diff", "sharing", "format-options", "complete",
+	//      "hover", "definition", "references", "outline"; "stdout"
+	//      and "output" carry Segments in Args
 	Kind string
 	Body string
 	Args []interface{}
+	// Scope is the scope an action's results are sent to, "private" or
+	// "room". It defaults to the scope of the room.
+	Scope string `json:",omitempty"`
 }
 
 func (m Message) String() string {
@@ -22,6 +37,45 @@ func (m Message) String() string {
 	return b.String()
 }
 
+// StringArg returns Args[i] if it is a string.
+func (m Message) StringArg(i int) string {
+	if i < len(m.Args) {
+		if s, ok := m.Args[i].(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// IntArg returns Args[i] if it is a number.
+func (m Message) IntArg(i int) int {
+	if i < len(m.Args) {
+		if f, ok := m.Args[i].(float64); ok {
+			return int(f)
+		}
+	}
+	return 0
+}
+
+// DecodeArg decodes Args[i] into v.
+func (m Message) DecodeArg(i int, v interface{}) error {
+	if i >= len(m.Args) {
+		return fmt.Errorf("missing argument %d", i)
+	}
+	data, err := json.Marshal(m.Args[i])
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Diagnostic is a message attached to a position of the document.
+type Diagnostic struct {
+	Line    int
+	Col     int
+	Message string
+}
+
 type Client struct {
 	Id   string
 	Name string
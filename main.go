@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"strconv"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/julien/gogala/lib"
@@ -15,15 +19,72 @@ import (
 
 const (
 	defaultName = "U-"
+	defaultRoom = "default"
 )
 
 var (
 	listenAddr = flag.String("addr", os.Getenv("PORT"), "Listen address")
-	clients    = make(map[string]lib.Client)
+	dataDir    = flag.String("data", filepath.Join(os.TempDir(), "gogala"), "Directory for room data")
+	localRun   = flag.Bool("local", false, "Build, run and test programs with the local go tool instead of the playground")
+	goroots    = flag.String("goroots", "", "Comma-separated GOROOTs of the Go toolchains rooms can choose from")
+	workers    = flag.Int("workers", runtime.NumCPU(), "Number of tests and builds done at the same time")
+	runWorkers = flag.Int("run-workers", runtime.NumCPU(), "Number of programs running at the same time")
+	jobTimeout = flag.Duration("job-timeout", time.Minute, "Time after which tests and builds are cancelled, runs get at least 5m")
+	cacheSize  = flag.Int64("cache-size", 64<<20, "Bytes of format, vet and build results to keep")
+	cacheTTL   = flag.Duration("cache-ttl", 10*time.Minute, "Time after which cached results expire")
+	importDirs = flag.String("import-roots", "", "Comma-separated module directories or GOPATH-like roots whose packages formatting imports")
+	clients    = lib.NewClients()
+	room       *lib.Room
+	scheduler  *lib.Scheduler
+	runners    *lib.Scheduler
+	editors    *lib.Scheduler
+	cache      *lib.Cache
+	toolchains []lib.Toolchain
+	imports    *lib.ImportIndex
 	debug      lib.Debug
 	verbose    bool
 )
 
+// scopedKinds are the actions whose results go to the room or only to
+// the client asking, see lib.Sharing.
+var scopedKinds = map[string]bool{
+	"format": true, "save": true, "compile": true, "live": true,
+	"test": true, "fuzz": true, "examples": true, "compare": true,
+	"build-matrix": true, "vet": true, "asm": true, "optimizations": true,
+	"profile": true, "trace": true,
+}
+
+// localKinds are the actions that build or run the document with the
+// local go tool, which needs -local. Editor features only load the
+// standard library.
+var localKinds = map[string]bool{
+	"live": true, "test": true, "fuzz": true, "examples": true,
+	"compare": true, "build-matrix": true, "vet": true, "asm": true,
+	"optimizations": true, "profile": true, "trace": true,
+}
+
+// autoRunJob is the last run started by an edit of the room.
+var autoRunJob struct {
+	sync.Mutex
+	job *lib.Job
+}
+
+// editorKinds are the editor features, which are scheduled apart from
+// runs and builds so that long runs don't hold them up.
+var editorKinds = map[string]bool{
+	"complete": true, "hover": true, "definition": true, "references": true,
+	"outline": true,
+}
+
+// runKinds are the runs of programs, which are scheduled apart from
+// tests and builds as they may serve a preview until they are killed.
+var runKinds = map[string]bool{
+	"compile": true, "live": true,
+}
+
+// editorTimeout is the timeout of editor features.
+const editorTimeout = 5 * time.Second
+
 func init() {
 	flag.BoolVar(&verbose, "verbose", false, "Debug mode")
 
@@ -37,9 +98,37 @@ func main() {
 
 	debug = lib.Debug(verbose)
 
+	var roots []string
+	if *goroots != "" {
+		roots = strings.Split(*goroots, ",")
+	}
+
+	var err error
+	if toolchains, err = lib.LoadToolchains(roots); err != nil {
+		log.Fatal(err)
+	}
+	if room, err = lib.NewRoom(defaultRoom, *dataDir); err != nil {
+		log.Fatal(err)
+	}
+	room.SetToolchain(toolchains[0])
+	for _, tc := range toolchains {
+		lib.WarmExports(tc)
+	}
+	scheduler = lib.NewScheduler(*workers, *jobTimeout)
+	runners = lib.NewScheduler(*runWorkers, runTimeout())
+	editors = lib.NewScheduler(*workers, editorTimeout)
+	cache = lib.NewCache(*cacheSize, *cacheTTL)
+	if *importDirs != "" {
+		if imports, err = lib.NewImportIndex(strings.Split(*importDirs, ",")); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	http.Handle("/", indexHandler())
 	http.Handle("/static/", lib.GZipHandler(lib.CacheHandler(30, staticHandler())))
 	http.Handle("/ws", websocket.Handler(wsHandler))
+	http.Handle("/trace/", traceHandler())
+	http.Handle(lib.PreviewPrefix, lib.PreviewHandler(room))
 
 	debug.Printf("Listening on: %s\n", *listenAddr)
 	log.Fatal(http.ListenAndServe(":"+*listenAddr, nil))
@@ -57,6 +146,25 @@ func staticHandler() http.Handler {
 	})
 }
 
+// traceHandler serves the raw execution traces of a room for go tool
+// trace at /trace/<room>/<name>, see lib.Room.TraceName.
+func traceHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.URL.Path[len("/trace/"):], "/")
+		if len(parts) != 2 || parts[0] != room.Name {
+			http.NotFound(w, r)
+			return
+		}
+		file := lib.TracePath(room, parts[1])
+		if file == "" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Disposition", "attachment; filename=trace.out")
+		http.ServeFile(w, r, file)
+	})
+}
+
 func wsHandler(ws *websocket.Conn) {
 	registerClient(ws)
 
@@ -72,9 +180,38 @@ func wsHandler(ws *websocket.Conn) {
 
 		debug.Printf("Received message: %s\n", msg.Kind)
 
+		var share bool
+		if scopedKinds[msg.Kind] {
+			var err error
+			if share, err = room.Shares(clientName(ws), msg.Scope); err != nil {
+				sendToClient(ws, lib.Message{Kind: "error", Body: err.Error()})
+				continue
+			}
+		}
+
+		if localKinds[msg.Kind] && !*localRun {
+			out = lib.Message{
+				Kind: "error",
+				Body: msg.Kind + " needs the local execution backend (-local)",
+			}
+			sendToClient(ws, out)
+			continue
+		}
+
 		switch msg.Kind {
 		case "format":
-			data, err := lib.Format([]byte(msg.Body))
+			// Args are the first and last line of the selection to
+			// format, if any.
+			src := []byte(msg.Body)
+			opts := room.FormatOptions()
+			opts.Index = imports
+			v, err := cache.Do(context.Background(), lib.CacheKey("format", lib.Toolchain{}, src, opts, msg.Args), func() (interface{}, error) {
+				if len(msg.Args) == 2 {
+					return lib.FormatRange(src, msg.IntArg(0), msg.IntArg(1), opts)
+				}
+				return lib.Format(src, opts)
+			})
+			data, _ := v.([]byte)
 			if err != nil {
 				debug.Printf("Format Error: %s\n", err)
 
@@ -83,19 +220,21 @@ func wsHandler(ws *websocket.Conn) {
 					Body: err.Error(),
 				}
 
-				if err := sendToAll(ws, out); err != nil {
+				if err := publish(ws, share, out); err != nil {
 					debug.Printf("Error sending message: %s\n", err)
 				}
 
 			}
 
-			if c := getClient(ws); c != nil {
+			// The edits change the submitted text to the formatted one
+			// without moving cursors.
+			if c := getClient(ws); c != nil && err == nil {
 				out = lib.Message{
 					Kind: "code",
 					Body: string(data),
-					Args: lib.MakeArgs(c.Name),
+					Args: lib.MakeArgs(c.Name, lib.LineEdits(msg.Body, string(data)), len(msg.Args) == 2),
 				}
-				if err := sendToAll(ws, out); err != nil {
+				if err := publish(ws, share, out); err != nil {
 					debug.Printf("Error sending message: %s\n", err)
 				}
 			}
@@ -118,32 +257,637 @@ func wsHandler(ws *websocket.Conn) {
 				Body: s,
 			}
 
-			if err := sendToAll(ws, out); err != nil {
+			if err := publish(ws, share, out); err != nil {
 				debug.Printf("Error sending message: %s\n", err)
 			}
 
 		case "compile":
-			data, err := lib.Compile(msg.Body)
+			if cfg := room.RunConfig(msg.StringArg(0)); cfg.NeedsLocal() && !*localRun {
+				out = lib.Message{
+					Kind: "error",
+					Body: "run configuration " + cfg.Name + " needs the local execution backend (-local)",
+				}
+				sendToClient(ws, out)
+				break
+			}
+			submit(ws, share, msg.Kind, runTimeout(), func(ctx context.Context, tc lib.Toolchain) {
+				if *localRun {
+					runLocal(ctx, ws, share, tc, msg.Body, room.RunConfig(msg.StringArg(0)), false)
+					return
+				}
+
+				rec := lib.NewRunRecorder(clientName(ws), []byte(msg.Body))
+				started := time.Now()
+
+				// Runs are not cached, their output may change with map
+				// order, scheduling or random numbers. Clients running the
+				// same code at the same time share the run.
+				v, err := cache.Share(ctx, lib.CacheKey("compile", lib.Toolchain{}, []byte(msg.Body)), func() (interface{}, error) {
+					return lib.Compile(ctx, msg.Body)
+				})
+				data, _ := v.([]byte)
+				if err != nil {
+					debug.Printf("Error compiling code (remote): %s\n", err)
+				}
+
+				cr, err := lib.ParseCompileResponse(data)
+				if err != nil {
+					debug.Printf("Error parsing compile response: %s\n", err)
+				}
+
+				// WTF!
+				if s := cr.Message(); s != nil {
+					if ok := s.(string); ok != "" {
+						out = lib.Message{
+							Kind: "stdout",
+							Args: lib.MakeArgs(lib.ParseOutput([]byte(s.(string)), room.RunConfig(msg.StringArg(0)).HTML)),
+						}
+
+						publish(ws, share, out)
+						rec.Write([]byte(ok))
+					}
+				}
+
+				if err == nil {
+					code, status := 0, "Program exited."
+					if cr.Errors != "" {
+						rec.Write([]byte(cr.Errors))
+						code, status = 1, "Program exited: build failed"
+					}
+					stats := lib.PlaygroundStats([]byte(msg.Body), started, code)
+					// Private runs stay out of the stats of the room.
+					if share {
+						room.AddRunStats(stats)
+					}
+					publish(ws, share, lib.Message{
+						Kind: "exit",
+						Body: status,
+						Args: lib.MakeArgs(stats, room.RunStats()),
+					})
+					if share {
+						addRun(ws, rec.Record(code, status))
+					}
+				}
+			})
+
+		case "live":
+			submit(ws, share, msg.Kind, runTimeout(), func(ctx context.Context, tc lib.Toolchain) {
+				runLocal(ctx, ws, share, tc, msg.Body, room.RunConfig(msg.StringArg(0)), true)
+			})
+
+		case "test":
+			submit(ws, share, msg.Kind, 0, func(ctx context.Context, tc lib.Toolchain) {
+				data, cov, err := lib.CoverTest(ctx, tc, []byte(msg.Body))
+				if err != nil {
+					debug.Printf("Error running tests: %s\n", err)
+
+					out = lib.Message{
+						Kind: "error",
+						Body: err.Error(),
+					}
+					publish(ws, share, out)
+				}
+
+				if len(data) > 0 {
+					out = lib.Message{
+						Kind: "stdout",
+						Args: lib.MakeArgs(lib.ParseOutput(data, false)),
+					}
+					publish(ws, share, out)
+				}
+
+				if cov != nil {
+					out = lib.Message{
+						Kind: "coverage",
+						Args: lib.MakeArgs(cov),
+					}
+					publish(ws, share, out)
+				}
+			})
+
+		case "fuzz":
+			// The job may take a minute more than fuzzing itself to
+			// build and save the corpus.
+			d := lib.FuzzTime(time.Duration(msg.IntArg(1)) * time.Second)
+			submit(ws, share, msg.Kind, d+time.Minute, func(ctx context.Context, tc lib.Toolchain) {
+				data, failure, err := lib.Fuzz(ctx, tc, room, []byte(msg.Body), msg.StringArg(0), d, func(p lib.FuzzProgress) {
+					publish(ws, share, lib.Message{
+						Kind: "fuzz",
+						Body: p.String(),
+						Args: lib.MakeArgs(p),
+					})
+				})
+				if err != nil {
+					debug.Printf("Error fuzzing: %s\n", err)
+
+					out = lib.Message{
+						Kind: "error",
+						Body: err.Error(),
+					}
+					publish(ws, share, out)
+				}
+
+				if len(data) > 0 {
+					out = lib.Message{
+						Kind: "stdout",
+						Args: lib.MakeArgs(lib.ParseOutput(data, false)),
+					}
+					publish(ws, share, out)
+				}
+
+				if failure != nil {
+					out = lib.Message{
+						Kind: "failure",
+						Body: failure.Input,
+						Args: lib.MakeArgs(failure),
+					}
+					publish(ws, share, out)
+				}
+			})
+
+		case "examples":
+			submit(ws, share, msg.Kind, 0, func(ctx context.Context, tc lib.Toolchain) {
+				data, results, diags, err := lib.RunExamples(ctx, tc, []byte(msg.Body))
+				if err != nil {
+					debug.Printf("Error running examples: %s\n", err)
+
+					out = lib.Message{
+						Kind: "error",
+						Body: err.Error(),
+					}
+					publish(ws, share, out)
+				}
+
+				if len(data) > 0 {
+					out = lib.Message{
+						Kind: "stdout",
+						Args: lib.MakeArgs(lib.ParseOutput(data, false)),
+					}
+					publish(ws, share, out)
+				}
+
+				if results != nil {
+					out = lib.Message{
+						Kind: "diagnostics",
+						Args: lib.MakeArgs(diags, results),
+					}
+					publish(ws, share, out)
+				}
+			})
+
+		case "kill":
+			// Stops servers being previewed too, the exit is reported
+			// by runLocal.
+			p, shared := userProcess(ws)
+			if shared && !room.MayShare(clientName(ws)) {
+				out = lib.Message{
+					Kind: "error",
+					Body: "the owner of the room restricted who may stop the program of the room",
+				}
+				sendToClient(ws, out)
+				break
+			}
+			if p != nil {
+				if err := p.Kill(); err != nil {
+					debug.Printf("Error killing program: %s\n", err)
+				}
+			}
+
+		case "stdin":
+			p, shared := userProcess(ws)
+			if shared && !room.MayShare(clientName(ws)) {
+				out = lib.Message{
+					Kind: "error",
+					Body: "the owner of the room restricted who may write to the program of the room",
+				}
+				sendToClient(ws, out)
+				break
+			}
+			if p == nil {
+				out = lib.Message{
+					Kind: "error",
+					Body: "No program is running",
+				}
+				sendToClient(ws, out)
+				break
+			}
+
+			if msg.StringArg(0) == "eof" {
+				p.CloseStdin()
+				break
+			}
+
+			if _, err := p.Write([]byte(msg.Body)); err != nil {
+				debug.Printf("Error writing to stdin: %s\n", err)
+				break
+			}
+
+			out = lib.Message{
+				Kind: "output",
+				Args: lib.MakeArgs("stdin", []lib.Segment{{Kind: "text", Text: msg.Body}}),
+			}
+			publish(ws, shared, out)
+
+		case "config":
+			if !room.MayShare(clientName(ws)) {
+				out = lib.Message{
+					Kind: "error",
+					Body: "the owner of the room restricted who may change its settings",
+				}
+				sendToClient(ws, out)
+				break
+			}
+			var cfg lib.RunConfig
+			err := msg.DecodeArg(0, &cfg)
+			if err == nil && cfg.NeedsLocal() && !*localRun {
+				err = fmt.Errorf("arguments, environment and build options need the local execution backend (-local)")
+			}
+			if err == nil {
+				err = room.SetRunConfig(cfg)
+			}
 			if err != nil {
-				debug.Printf("Error compiling code (remote): %s\n", err)
+				out = lib.Message{
+					Kind: "error",
+					Body: err.Error(),
+				}
+				sendToClient(ws, out)
+				break
+			}
+
+			out = lib.Message{
+				Kind: "config",
+				Args: lib.MakeArgs(room.RunConfigs()),
 			}
+			sendToAll(ws, out)
 
-			cr, err := lib.ParseCompileResponse(data)
+		case "format-options":
+			if !room.MayShare(clientName(ws)) {
+				out = lib.Message{
+					Kind: "error",
+					Body: "the owner of the room restricted who may change its settings",
+				}
+				sendToClient(ws, out)
+				break
+			}
+			var opts lib.FormatOptions
+			err := msg.DecodeArg(0, &opts)
+			if err == nil {
+				err = room.SetFormatOptions(opts)
+			}
 			if err != nil {
-				debug.Printf("Error parsing compile response: %s\n", err)
+				out = lib.Message{
+					Kind: "error",
+					Body: err.Error(),
+				}
+				sendToClient(ws, out)
+				break
+			}
+
+			out = lib.Message{
+				Kind: "format-options",
+				Args: lib.MakeArgs(room.FormatOptions()),
+			}
+			sendToAll(ws, out)
+
+		case "toolchain":
+			if !room.MayShare(clientName(ws)) {
+				out = lib.Message{
+					Kind: "error",
+					Body: "the owner of the room restricted who may change its settings",
+				}
+				sendToClient(ws, out)
+				break
+			}
+			tc, ok := lib.FindToolchain(toolchains, msg.Body)
+			if !ok {
+				out = lib.Message{
+					Kind: "error",
+					Body: "Unknown toolchain " + msg.Body,
+				}
+				sendToClient(ws, out)
+				break
+			}
+			room.SetToolchain(tc)
+			sendToAll(ws, toolchainMessage())
+
+		case "compare":
+			var versions []string
+			if len(msg.Args) > 1 {
+				if err := msg.DecodeArg(1, &versions); err != nil {
+					out = lib.Message{
+						Kind: "error",
+						Body: "Invalid toolchain versions: " + err.Error(),
+					}
+					sendToClient(ws, out)
+					break
+				}
 			}
 
-			// WTF!
-			if s := cr.Message(); s != nil {
-				if ok := s.(string); ok != "" {
+			tcs := toolchains
+			if len(versions) > 0 {
+				tcs = nil
+				var unknown []string
+				for _, v := range versions {
+					if t, ok := lib.FindToolchain(toolchains, v); ok {
+						tcs = append(tcs, t)
+					} else {
+						unknown = append(unknown, v)
+					}
+				}
+				if len(unknown) > 0 {
+					out = lib.Message{
+						Kind: "error",
+						Body: "Unknown toolchain " + strings.Join(unknown, ", "),
+					}
+					sendToClient(ws, out)
+					break
+				}
+			}
+			submit(ws, share, msg.Kind, lib.CompareTimeout(len(tcs)), func(ctx context.Context, tc lib.Toolchain) {
+				results := lib.Compare(ctx, tcs, []byte(msg.Body), room.RunConfig(msg.StringArg(0)))
+				out = lib.Message{
+					Kind: "compare",
+					Args: lib.MakeArgs(results),
+				}
+				publish(ws, share, out)
+			})
+
+		case "build-matrix":
+			var targets []lib.BuildTarget
+			if len(msg.Args) > 0 {
+				if err := msg.DecodeArg(0, &targets); err != nil {
+					out = lib.Message{
+						Kind: "error",
+						Body: "Invalid build targets: " + err.Error(),
+					}
+					sendToClient(ws, out)
+					break
+				}
+			}
+			submit(ws, share, msg.Kind, 0, func(ctx context.Context, tc lib.Toolchain) {
+				// The defaults are shared, requests get a copy.
+				if len(targets) == 0 {
+					targets = append(targets, lib.DefaultBuildTargets...)
+				}
+				if err := lib.ValidateBuildTargets(targets); err != nil {
+					publish(ws, share, lib.Message{Kind: "error", Body: err.Error()})
+					return
+				}
+
+				src := []byte(msg.Body)
+				v, err := cache.Do(ctx, lib.CacheKey(msg.Kind, tc, src, targets), func() (interface{}, error) {
+					return lib.BuildMatrix(ctx, tc, src, targets)
+				})
+				results, _ := v.([]lib.BuildResult)
+				if err != nil {
+					debug.Printf("Error building matrix: %s\n", err)
+
+					out = lib.Message{
+						Kind: "error",
+						Body: err.Error(),
+					}
+					publish(ws, share, out)
+					return
+				}
+
+				out = lib.Message{
+					Kind: "build-matrix",
+					Args: lib.MakeArgs(results),
+				}
+				publish(ws, share, out)
+			})
+
+		case "vet":
+			submit(ws, share, msg.Kind, 0, func(ctx context.Context, tc lib.Toolchain) {
+				src := []byte(msg.Body)
+				v, err := cache.Do(ctx, lib.CacheKey(msg.Kind, tc, src), func() (interface{}, error) {
+					return lib.Vet(ctx, tc, src)
+				})
+				if err != nil {
+					out = lib.Message{
+						Kind: "error",
+						Body: err.Error(),
+					}
+					publish(ws, share, out)
+					return
+				}
+
+				out = lib.Message{
+					Kind: "diagnostics",
+					Body: "vet",
+					Args: lib.MakeArgs(v.([]lib.Diagnostic)),
+				}
+				publish(ws, share, out)
+			})
+
+		case "complete":
+			// The offset is counted in UTF-16 units like in the editor, it
+			// is sent back for the client to match the reply.
+			submit(ws, false, msg.Kind, 0, func(ctx context.Context, tc lib.Toolchain) {
+				src := []byte(msg.Body)
+				cands, err := lib.Complete(ctx, tc, src, lib.ByteOffset(src, msg.IntArg(0)))
+				if err != nil {
+					out = lib.Message{
+						Kind: "error",
+						Body: err.Error(),
+					}
+					sendToClient(ws, out)
+					return
+				}
+
+				out = lib.Message{
+					Kind: "complete",
+					Args: lib.MakeArgs(cands, msg.IntArg(0)),
+				}
+				sendToClient(ws, out)
+			})
+
+		case "hover":
+			submit(ws, false, msg.Kind, 0, func(ctx context.Context, tc lib.Toolchain) {
+				src := []byte(msg.Body)
+				h, err := lib.Hover(ctx, tc, src, lib.ByteOffset(src, msg.IntArg(0)))
+				if err != nil {
+					out = lib.Message{
+						Kind: "error",
+						Body: err.Error(),
+					}
+					sendToClient(ws, out)
+					return
+				}
+				if h != nil {
+					h.Start, h.End = lib.UTF16Offset(src, h.Start), lib.UTF16Offset(src, h.End)
+				}
+
+				out = lib.Message{
+					Kind: "hover",
+					Args: lib.MakeArgs(h, msg.IntArg(0)),
+				}
+				sendToClient(ws, out)
+			})
+
+		case "definition", "references", "outline":
+			// Args are the offset of the cursor, sent back with the
+			// reply like for completion.
+			submit(ws, false, msg.Kind, 0, func(ctx context.Context, tc lib.Toolchain) {
+				src := []byte(msg.Body)
+				offset := lib.ByteOffset(src, msg.IntArg(0))
+				var v interface{}
+				var err error
+				switch msg.Kind {
+				case "definition":
+					v, err = lib.Definition(ctx, tc, src, offset)
+				case "references":
+					v, err = lib.References(ctx, tc, src, offset)
+				default:
+					v, err = lib.Outline(ctx, tc, src)
+				}
+				if err != nil {
+					out = lib.Message{
+						Kind: "error",
+						Body: err.Error(),
+					}
+					sendToClient(ws, out)
+					return
+				}
+
+				out = lib.Message{
+					Kind: msg.Kind,
+					Args: lib.MakeArgs(v, msg.IntArg(0)),
+				}
+				sendToClient(ws, out)
+			})
+
+		case "asm":
+			submit(ws, share, msg.Kind, 0, func(ctx context.Context, tc lib.Toolchain) {
+				src := []byte(msg.Body)
+				v, err := cache.Do(ctx, lib.CacheKey(msg.Kind, tc, src), func() (interface{}, error) {
+					return lib.Assembly(ctx, tc, src)
+				})
+				funcs, _ := v.([]lib.AsmFunc)
+				if err != nil {
+					out = lib.Message{
+						Kind: "error",
+						Body: err.Error(),
+					}
+					publish(ws, share, out)
+					return
+				}
+
+				out = lib.Message{
+					Kind: "overlay",
+					Body: "asm",
+					Args: lib.MakeArgs(funcs),
+				}
+				publish(ws, share, out)
+			})
+
+		case "optimizations":
+			submit(ws, share, msg.Kind, 0, func(ctx context.Context, tc lib.Toolchain) {
+				src := []byte(msg.Body)
+				v, err := cache.Do(ctx, lib.CacheKey(msg.Kind, tc, src), func() (interface{}, error) {
+					return lib.Optimizations(ctx, tc, src)
+				})
+				annotations, _ := v.([]lib.Annotation)
+				if err != nil {
+					out = lib.Message{
+						Kind: "error",
+						Body: err.Error(),
+					}
+					publish(ws, share, out)
+					return
+				}
+
+				out = lib.Message{
+					Kind: "overlay",
+					Body: "optimizations",
+					Args: lib.MakeArgs(annotations),
+				}
+				publish(ws, share, out)
+			})
+
+		case "profile":
+			submit(ws, share, msg.Kind, 0, func(ctx context.Context, tc lib.Toolchain) {
+				data, reports, err := lib.Profile(ctx, tc, []byte(msg.Body), msg.StringArg(0))
+				if err != nil {
+					debug.Printf("Error profiling: %s\n", err)
+
+					out = lib.Message{
+						Kind: "error",
+						Body: err.Error(),
+					}
+					publish(ws, share, out)
+				}
+
+				if len(data) > 0 {
 					out = lib.Message{
 						Kind: "stdout",
-						Body: s.(string),
+						Args: lib.MakeArgs(lib.ParseOutput(data, false)),
 					}
+					publish(ws, share, out)
+				}
 
-					sendToAll(ws, out)
+				if len(reports) > 0 {
+					out = lib.Message{
+						Kind: "profile",
+						Args: lib.MakeArgs(reports),
+					}
+					publish(ws, share, out)
+				}
+			})
+
+		case "trace":
+			submit(ws, share, msg.Kind, 0, func(ctx context.Context, tc lib.Toolchain) {
+				name := room.TraceName(clientName(ws), share)
+				started := time.Now()
+				data, report, err := lib.Trace(ctx, tc, room, name, []byte(msg.Body), msg.StringArg(0))
+				if err != nil {
+					debug.Printf("Error tracing: %s\n", err)
+
+					out = lib.Message{
+						Kind: "error",
+						Body: err.Error(),
+					}
+					publish(ws, share, out)
+				}
+
+				if len(data) > 0 {
+					out = lib.Message{
+						Kind: "stdout",
+						Args: lib.MakeArgs(lib.ParseOutput(data, false)),
+					}
+					publish(ws, share, out)
+				}
+
+				if report != nil {
+					stats := lib.TraceStats([]byte(msg.Body), started, report)
+					if share {
+						room.AddRunStats(stats)
+					}
+					out = lib.Message{
+						Kind: "trace",
+						Body: "/trace/" + room.Name + "/" + name,
+						Args: lib.MakeArgs(report, stats, room.RunStats()),
+					}
+					publish(ws, share, out)
+				}
+			})
+
+		case "history":
+			if len(msg.Args) == 2 {
+				diff, err := room.DiffRuns(msg.IntArg(0), msg.IntArg(1))
+				if err != nil {
+					out = lib.Message{Kind: "error", Body: err.Error()}
+				} else {
+					out = lib.Message{
+						Kind: "history-diff",
+						Body: diff,
+						Args: msg.Args,
+					}
 				}
+				sendToClient(ws, out)
+				break
 			}
+			sendToClient(ws, historyMessage())
 
 		case "chat":
 			t := time.Now().Format(time.Kitchen)
@@ -156,7 +900,79 @@ func wsHandler(ws *websocket.Conn) {
 				sendToAll(ws, out)
 			}
 
+		case "autorun":
+			var a lib.AutoRun
+			err := msg.DecodeArg(0, &a)
+			if err == nil && a.Enabled && !*localRun {
+				err = fmt.Errorf("auto-run needs the local execution backend (-local)")
+			}
+			if err == nil && !room.MayShare(clientName(ws)) {
+				err = fmt.Errorf("the owner of the room restricted who may run for the room")
+			}
+			if err == nil {
+				err = room.SetAutoRun(a)
+			}
+			if err != nil {
+				out = lib.Message{
+					Kind: "error",
+					Body: err.Error(),
+				}
+				sendToClient(ws, out)
+				break
+			}
+
+			out = lib.Message{
+				Kind: "autorun",
+				Args: lib.MakeArgs(room.AutoRun()),
+			}
+			sendToAll(ws, out)
+
+		case "sharing":
+			var sh lib.Sharing
+			err := msg.DecodeArg(0, &sh)
+			if err == nil {
+				err = room.SetSharing(clientName(ws), sh)
+			}
+			if err != nil {
+				out = lib.Message{
+					Kind: "error",
+					Body: err.Error(),
+				}
+				sendToClient(ws, out)
+				break
+			}
+			sendToAll(ws, sharingMessage())
+
+		case "cancel":
+			if c := getClient(ws); c != nil {
+				scheduler.Cancel(room.Name, c.Id)
+				runners.Cancel(room.Name, c.Id)
+				editors.Cancel(room.Name, c.Id)
+			}
+
 		case "update":
+			room.Edited([]byte(msg.Body), func(src []byte) {
+				// Those who may not share get the run to themselves. The
+				// program before is outdated, it stops while this one builds.
+				a := room.AutoRun()
+				share := room.MayShare(clientName(ws))
+				if share {
+					room.SetProcess(nil)
+				} else {
+					room.SetPrivateProcess(clientName(ws), nil)
+				}
+				// So does the auto-run before, if it is still queued or
+				// building.
+				autoRunJob.Lock()
+				if autoRunJob.job != nil {
+					runners.CancelJob(autoRunJob.job)
+				}
+				autoRunJob.job = submit(ws, share, "compile", runTimeout(), func(ctx context.Context, tc lib.Toolchain) {
+					runLocal(ctx, ws, share, tc, string(src), room.RunConfig(a.Config), a.Live)
+				})
+				autoRunJob.Unlock()
+			})
+
 			if c := getClient(ws); c != nil {
 				out = lib.Message{
 					Kind: "update",
@@ -173,19 +989,180 @@ func wsHandler(ws *websocket.Conn) {
 	}
 }
 
-func registerClient(ws *websocket.Conn) {
+// submit schedules run as a job of the client of ws, which is told its
+// place in the queue until the job starts. run gets the toolchain of
+// the room and the context of the job, which is done when the job is
+// cancelled or times out. share tells who hears about a timeout. The
+// job is returned, nil if it could not be queued.
+func submit(ws *websocket.Conn, share bool, kind string, timeout time.Duration, run func(ctx context.Context, tc lib.Toolchain)) *lib.Job {
+	user := ""
+	if c := getClient(ws); c != nil {
+		user = c.Id
+	}
+
+	s := scheduler
+	switch {
+	case runKinds[kind]:
+		s = runners
+	case editorKinds[kind]:
+		s = editors
+	}
+	j := &lib.Job{
+		Room:    room.Name,
+		User:    user,
+		Kind:    kind,
+		Timeout: timeout,
+		Run: func(ctx context.Context) {
+			run(ctx, room.Toolchain())
+			if ctx.Err() == context.DeadlineExceeded {
+				publish(ws, share, lib.Message{Kind: "error", Body: kind + " timed out"})
+			}
+		},
+		Failed: func(err error) {
+			sendToClient(ws, lib.Message{Kind: "error", Body: err.Error()})
+		},
+		Position: func(n int) {
+			sendToClient(ws, lib.Message{
+				Kind: "queue",
+				Body: kind,
+				Args: lib.MakeArgs(n),
+			})
+		},
+	}
+	if err := s.Submit(j); err != nil {
+		sendToClient(ws, lib.Message{Kind: "error", Body: err.Error()})
+		return nil
+	}
+	return j
+}
+
+// runTimeout is the timeout of jobs running programs, which may run
+// for lib.MaxRunTime.
+func runTimeout() time.Duration {
+	if *jobTimeout > lib.MaxRunTime {
+		return *jobTimeout
+	}
+	return lib.MaxRunTime
+}
+
+// runLocal runs src with the local execution backend and streams its
+// output to the room, or only to the client of ws if the run is not
+// shared. In live mode the values the program prints and assigns are
+// sent with their line too. It returns once the program exited, which
+// is killed when ctx is done, so that runs hold their worker.
+func runLocal(ctx context.Context, ws *websocket.Conn, share bool, tc lib.Toolchain, src string, cfg lib.RunConfig, live bool) {
+	publish(ws, share, lib.Message{Kind: "run", Body: cfg.Name, Args: lib.MakeArgs(live)})
+	rec := lib.NewRunRecorder(clientName(ws), []byte(src))
 
-	n := len(clients)
-	u := strconv.Itoa(n)
-	if n < 10 {
-		u = "0" + u
+	// Each stream is parsed on its own goroutine.
+	parsers := map[string]*lib.OutputParser{
+		"stdout": {HTML: cfg.HTML},
+		"stderr": {},
+	}
+	send := func(stream string, segs []lib.Segment) {
+		if len(segs) > 0 {
+			publish(ws, share, lib.Message{
+				Kind: "output",
+				Args: lib.MakeArgs(stream, segs),
+			})
+		}
+	}
+	output := func(stream string, data []byte) {
+		rec.Write(data)
+		send(stream, parsers[stream].Write(data))
+	}
+
+	var p *lib.Process
+	var err error
+	if live {
+		p, err = lib.StartLive(ctx, tc, []byte(src), cfg, output, func(v lib.LiveValue) {
+			publish(ws, share, lib.Message{
+				Kind: "value",
+				Args: lib.MakeArgs(v),
+			})
+		})
+	} else {
+		p, err = lib.Start(ctx, tc, []byte(src), cfg, output)
+	}
+	if err != nil {
+		debug.Printf("Error starting program: %s\n", err)
+		publish(ws, share, lib.Message{Kind: "error", Body: err.Error()})
+		return
+	}
+	// Only the program of the room is previewed.
+	if !share {
+		room.SetPrivateProcess(clientName(ws), p)
+	} else {
+		room.SetProcess(p)
+		publish(ws, share, lib.Message{
+			Kind: "preview",
+			Body: lib.PreviewURL(room),
+			Args: lib.MakeArgs(p.Port()),
+		})
 	}
 
-	// Store client in clients map
-	clients[u] = lib.Client{
-		Id:   u,
-		Name: defaultName + u,
-		Conn: ws,
+	s := "Program exited."
+	if err := p.Wait(); err != nil {
+		s = "Program exited: " + err.Error()
+	}
+	for _, stream := range []string{"stdout", "stderr"} {
+		send(stream, parsers[stream].Flush())
+	}
+	if share {
+		room.AddRunStats(p.Stats())
+	}
+	publish(ws, share, lib.Message{
+		Kind: "exit",
+		Body: s,
+		Args: lib.MakeArgs(p.Stats(), room.RunStats()),
+	})
+	if share {
+		addRun(ws, rec.Record(p.Stats().ExitCode, s))
+	}
+}
+
+// userProcess returns the program the client of ws runs privately, or
+// else the program of the room and true.
+func userProcess(ws *websocket.Conn) (*lib.Process, bool) {
+	if p := room.PrivateProcess(clientName(ws)); p.Running() {
+		return p, false
+	}
+	return room.Process(), true
+}
+
+// addRun adds a finished run to the history of the room and sends it
+// to everybody.
+func addRun(ws *websocket.Conn, rec lib.RunRecord) {
+	rec = room.AddRun(rec)
+	sendToAll(ws, lib.Message{
+		Kind: "history",
+		Body: "append",
+		Args: lib.MakeArgs([]lib.RunRecord{rec}),
+	})
+}
+
+// sharingMessage tells clients who owns the room and how results are
+// shared.
+func sharingMessage() lib.Message {
+	return lib.Message{
+		Kind: "sharing",
+		Body: room.Owner(),
+		Args: lib.MakeArgs(room.Sharing()),
+	}
+}
+
+// historyMessage sends the whole run history of the room.
+func historyMessage() lib.Message {
+	return lib.Message{
+		Kind: "history",
+		Args: lib.MakeArgs(room.RunHistory()),
+	}
+}
+
+func registerClient(ws *websocket.Conn) {
+	c := clients.Add(ws, defaultName)
+	if room.Owner() == "" {
+		room.SetOwner(c.Name)
 	}
 
 	// Send welcome message
@@ -193,8 +1170,8 @@ func registerClient(ws *websocket.Conn) {
 
 	msg = lib.Message{
 		Kind: "info",
-		Body: lib.AppendString("[", lib.PrintTimeStamp(), "] ", "Welcome, ", clients[u].Name),
-		Args: lib.MakeArgs(clients[u].Name),
+		Body: lib.AppendString("[", lib.PrintTimeStamp(), "] ", "Welcome, ", c.Name),
+		Args: lib.MakeArgs(c.Name),
 	}
 
 	if err := sendToClient(ws, msg); err != nil {
@@ -203,48 +1180,112 @@ func registerClient(ws *websocket.Conn) {
 
 	msg = lib.Message{
 		Kind: "info",
-		Body: lib.AppendString("[", lib.PrintTimeStamp(), "] ", clients[u].Name, " joined"),
+		Body: lib.AppendString("[", lib.PrintTimeStamp(), "] ", c.Name, " joined"),
 	}
 
 	if err := sendToOthers(ws, msg); err != nil {
 		debug.Printf("Error sending mesaage to others: %s\n", err)
 	}
+
+	msg = lib.Message{
+		Kind: "config",
+		Args: lib.MakeArgs(room.RunConfigs()),
+	}
+
+	if err := sendToClient(ws, msg); err != nil {
+		debug.Printf("Error sending message: %s\n", err)
+	}
+
+	if err := sendToClient(ws, toolchainMessage()); err != nil {
+		debug.Printf("Error sending message: %s\n", err)
+	}
+
+	msg = lib.Message{
+		Kind: "format-options",
+		Args: lib.MakeArgs(room.FormatOptions()),
+	}
+
+	if err := sendToClient(ws, msg); err != nil {
+		debug.Printf("Error sending message: %s\n", err)
+	}
+
+	msg = lib.Message{
+		Kind: "autorun",
+		Args: lib.MakeArgs(room.AutoRun()),
+	}
+
+	if err := sendToClient(ws, msg); err != nil {
+		debug.Printf("Error sending message: %s\n", err)
+	}
+
+	if err := sendToClient(ws, historyMessage()); err != nil {
+		debug.Printf("Error sending message: %s\n", err)
+	}
+
+	if err := sendToClient(ws, sharingMessage()); err != nil {
+		debug.Printf("Error sending message: %s\n", err)
+	}
 }
 
-func unregisterClient(ws *websocket.Conn) {
-	var n string
+// toolchainMessage tells clients the toolchain of the room and the
+// ones it can switch to.
+func toolchainMessage() lib.Message {
+	versions := make([]string, len(toolchains))
+	for i, tc := range toolchains {
+		versions[i] = tc.Version
+	}
+	return lib.Message{
+		Kind: "toolchain",
+		Body: room.Toolchain().Version,
+		Args: lib.MakeArgs(versions),
+	}
+}
 
-	// Remove client
-	for k, v := range clients {
-		if v.Conn == ws {
-			n = k
-			delete(clients, k)
-		}
+func unregisterClient(ws *websocket.Conn) {
+	c, ok := clients.Remove(ws)
+	if !ok {
+		return
 	}
+	scheduler.Cancel(room.Name, c.Id)
+	runners.Cancel(room.Name, c.Id)
+	editors.Cancel(room.Name, c.Id)
+	room.SetPrivateProcess(c.Name, nil)
+
+	others := clients.List()
 
-	s := false
-	if len(clients) == 1 {
-		s = true
+	// The room passes to whoever joined next.
+	if room.Owner() == c.Name {
+		owner := ""
+		if len(others) > 0 {
+			owner = others[0].Name
+		}
+		room.SetOwner(owner)
+		sendToOthers(ws, sharingMessage())
 	}
 
 	// Notify
 	msg := lib.Message{
 		Kind: "leave",
-		Body: n,
-		Args: lib.MakeArgs(s),
+		Body: c.Id,
+		Args: lib.MakeArgs(len(others) == 1),
 	}
 
 	sendToOthers(ws, msg)
 }
 
 func getClient(ws *websocket.Conn) *lib.Client {
-	var c *lib.Client
-	for _, v := range clients {
-		if v.Conn == ws {
-			return &v
-		}
+	if c, ok := clients.Get(ws); ok {
+		return &c
+	}
+	return nil
+}
+
+// clientName returns the name of the client, or "" once it left.
+func clientName(ws *websocket.Conn) string {
+	if c := getClient(ws); c != nil {
+		return c.Name
 	}
-	return c
+	return ""
 }
 
 func sendToClient(ws *websocket.Conn, msg lib.Message) error {
@@ -254,26 +1295,42 @@ func sendToClient(ws *websocket.Conn, msg lib.Message) error {
 	return nil
 }
 
+// sendToOthers sends msg to every client but the one of ws. It returns
+// the first error, clients after it still get the message.
 func sendToOthers(ws *websocket.Conn, msg lib.Message) error {
-	for _, v := range clients {
-		if v.Conn != ws {
-			return sendToClient(v.Conn, msg)
+	var first error
+	for _, c := range clients.List() {
+		if c.Conn == ws {
+			continue
+		}
+		if err := sendToClient(c.Conn, msg); err != nil {
+			debug.Printf("Error sending message to %s: %s\n", c.Name, err)
+			if first == nil {
+				first = err
+			}
 		}
 	}
-	return nil
+	return first
 }
 
+// sendToAll sends msg to every client, the one of ws first, which may
+// have left already.
 func sendToAll(ws *websocket.Conn, msg lib.Message) error {
-
-	if err := sendToClient(ws, msg); err != nil {
+	err := sendToClient(ws, msg)
+	if err != nil {
 		debug.Printf("Error sending message to client: %s\n", err)
-		return err
 	}
-
-	if err := sendToOthers(ws, msg); err != nil {
-		debug.Printf("Error sending message to others: %s\n", err)
-		return err
+	if err2 := sendToOthers(ws, msg); err == nil {
+		err = err2
 	}
+	return err
+}
 
-	return nil
+// publish sends msg to everybody if share is set, else only to the
+// client of ws.
+func publish(ws *websocket.Conn, share bool, msg lib.Message) error {
+	if share {
+		return sendToAll(ws, msg)
+	}
+	return sendToClient(ws, msg)
 }
@@ -9,9 +9,31 @@
   var output = document.getElementById('js-output');
   var instructions = document.getElementById('js-instructions-tpl');
   var gistBtn = document.getElementById('js-btn-gist');
+  var testBtn = document.getElementById('js-btn-test');
   var chatTxt = document.getElementById('js-chat-txt');
   var chatInput = document.getElementById('js-chat-input');
+  var stdinInput = document.getElementById('js-stdin');
+  var configSelect = document.getElementById('js-config');
+  var toolchainSelect = document.getElementById('js-toolchain');
   var ws = null;
+  var markers = [];
+  var liveValues = {};
+  var liveMarker = null;
+  var autoRun = null;
+  var runHistory = [];
+  // scope is where results of this client's actions go, '' for the
+  // default of the room.
+  var scope = '';
+  var sharing = null;
+  var formatOptions = null;
+  // pendingCompletion is the callback of the completion request waiting
+  // for its reply, with the offset it was asked at.
+  var pendingCompletion = null;
+  // pendingHover is the hover request waiting for its reply, shown at
+  // x, y or in the output if it has no position.
+  var pendingHover = null;
+  var hoverTooltip = null;
+  var hoverTimer = null;
 
   // "Controllers"
   var msgCtrl = {
@@ -23,12 +45,22 @@
       setChatText(data.Body);
     },
 
+    // code is formatted code, with the edits from the text sent and
+    // whether only a selection was formatted.
     code: function (data) {
-      if (data.Body) {
+      var args = data.Args || [];
+      // Every client applies the edits, only the one who asked sends
+      // the result to the room.
+      editor.getSession().off('change', changeText);
+      if (data.Body && !applyEdits(args[1] || [], data.Body)) {
         setText(data.Body, true);
       }
-      if (Array.isArray(data.Args) && data.Args[0] === clientId)  {
-        sendCode(data.Body);
+      editor.getSession().on('change', changeText);
+      if (args[0] === clientId) {
+        changeText();
+        if (!args[2]) {
+          sendCode(data.Body);
+        }
       }
     },
 
@@ -41,7 +73,312 @@
     },
 
     stdout: function (data) {
-      setOutput(data.Body, true);
+      output.innerHTML = '';
+      appendSegments(data.Args && data.Args[0], 'stdout');
+    },
+
+    coverage: function (data) {
+      var cov = data.Args && data.Args[0];
+      clearMarkers();
+      if (!cov) { return; }
+      addMarkers(cov.Covered, 'cov-covered');
+      addMarkers(cov.Uncovered, 'cov-uncovered');
+    },
+
+    fuzz: function (data) {
+      setOutput(data.Body);
+    },
+
+    failure: function (data) {
+      setOutput('Failing input:\n' + data.Body);
+    },
+
+    diagnostics: function (data) {
+      var diags = (data.Args && data.Args[0]) || [];
+      if (data.Body === 'vet' && diags.length === 0) {
+        setOutput('go vet found no problems');
+      }
+      diags.forEach(function (d) {
+        setOutput(d.Line + ':' + d.Col + ': ' + d.Message);
+      });
+      // Example mismatches are on the lines of the output comment.
+      annotate(diags, data.Body === 'vet' ? 'warning' : 'error');
+    },
+
+    config: function (data) {
+      var configs = (data.Args && data.Args[0]) || [];
+      var selected = configSelect.value || 'default';
+      configSelect.innerHTML = '';
+      configs.forEach(function (c) {
+        var opt = document.createElement('option');
+        opt.value = opt.textContent = c.Name;
+        opt.selected = c.Name === selected;
+        configSelect.appendChild(opt);
+      });
+    },
+
+    toolchain: function (data) {
+      var versions = (data.Args && data.Args[0]) || [];
+      toolchainSelect.innerHTML = '';
+      versions.forEach(function (v) {
+        var opt = document.createElement('option');
+        opt.value = opt.textContent = v;
+        opt.selected = v === data.Body;
+        toolchainSelect.appendChild(opt);
+      });
+    },
+
+    autorun: function (data) {
+      var a = data.Args && data.Args[0];
+      if (!a) { return; }
+      if (autoRun && autoRun.Enabled !== a.Enabled) {
+        setChatText('Auto-run ' + (a.Enabled ? 'on (' + a.Delay + 'ms' + (a.Live ? ', live' : '') + ')' : 'off'));
+      }
+      autoRun = a;
+    },
+
+    preview: function (data) {
+      var a = document.createElement('a');
+      a.href = data.Body;
+      a.target = '_blank';
+      a.textContent = 'Preview (servers listening on $PORT)';
+      output.appendChild(a);
+    },
+
+    queue: function (data) {
+      var n = data.Args && data.Args[0];
+      if (n > 0) {
+        setChatText(data.Body + ': you are #' + n + ' in the queue (:cancel to give up)');
+      } else if (n < 0) {
+        setChatText(data.Body + ': cancelled');
+      }
+    },
+
+    compare: function (data) {
+      var results = (data.Args && data.Args[0]) || [];
+      output.innerHTML = '';
+      results.forEach(function (r, i) {
+        setOutput('== ' + r.Version + ' (' + r.Status + ')');
+        setOutput(i > 0 && r.Diff ? r.Diff : r.Output);
+      });
+    },
+
+    'build-matrix': function (data) {
+      var results = (data.Args && data.Args[0]) || [];
+      output.innerHTML = '';
+      results.forEach(function (r) {
+        var t = r.Target;
+        var name = t.GOOS + '/' + t.GOARCH + (t.Tags && t.Tags.length ? ' [' + t.Tags.join(',') + ']' : '');
+        setOutput((r.Passed ? 'PASS ' : 'FAIL ') + name);
+        (r.Diagnostics || []).forEach(function (d) {
+          setOutput('  ' + d.Line + ':' + d.Col + ': ' + d.Message);
+        });
+      });
+    },
+
+    // overlay shows the assembly or the optimization notes in the gutter
+    // of the lines they are for, and in full in the output.
+    overlay: function (data) {
+      var items = (data.Args && data.Args[0]) || [];
+      output.innerHTML = '';
+      if (data.Body === 'asm') {
+        var byLine = {};
+        items.forEach(function (fn) {
+          setOutput(fn.Name + ' (line ' + fn.Line + ')');
+          setOutput((fn.Instructions || []).map(function (i) {
+            if (i.Line) {
+              (byLine[i.Line] = byLine[i.Line] || []).push(i.Text);
+            }
+            return (i.Line || '-') + '\t' + i.Text;
+          }).join('\n'));
+        });
+        annotate(Object.keys(byLine).map(function (line) {
+          return { Line: +line, Message: byLine[line].join('\n') };
+        }), 'info');
+        return;
+      }
+      items.forEach(function (a) {
+        setOutput(a.Line + ':' + a.Col + ' [' + a.Kind + '] ' + a.Message);
+      });
+      annotate(items.map(function (a) {
+        return { Line: a.Line, Col: a.Col, Message: '[' + a.Kind + '] ' + [a.Message].concat(a.Details || []).join('\n') };
+      }), 'info');
+    },
+
+    profile: function (data) {
+      var reports = (data.Args && data.Args[0]) || [];
+      reports.forEach(function (r) {
+        setOutput(r.Kind + ' (total ' + r.Total + ' ' + r.Unit + ')\n' +
+          (r.Top || []).map(function (e) {
+            return e.Flat + '\t' + e.Cum + '\t' + e.Func;
+          }).join('\n'));
+      });
+    },
+
+    trace: function (data) {
+      var r = data.Args && data.Args[0];
+      if (!r) { return; }
+      setOutput('trace ' + ms(r.Duration) + (r.Truncated ? ' (truncated)' : ''));
+      setOutput('goroutine\tstart\tend\trunning\trunnable\twaiting\tfunc\n' +
+        (r.Goroutines || []).map(function (g) {
+          return g.ID + '\t' + ms(g.Start) + '\t' + (g.End ? ms(g.End) : '-') + '\t' +
+            ms(g.Running) + '\t' + ms(g.Runnable) + '\t' + ms(g.Waiting) + '\t' + g.Func;
+        }).join('\n'));
+      setOutput('blocked\n' + (r.Blocks || []).filter(function (b) {
+        return b.Line;
+      }).map(function (b) {
+        return 'line ' + b.Line + '\tG' + b.Goroutine + '\t' + b.Reason + '\t' +
+          (b.End ? ms(b.End - b.Start) : 'until exit');
+      }).join('\n'));
+      setOutput((r.Procs || []).map(function (p) {
+        var busy = (p.Slices || []).reduce(function (t, s) { return t + s.End - s.Start; }, 0);
+        return 'P' + p.ID + '\t' + (r.Duration ? Math.round(100 * busy / r.Duration) : 0) + '% busy, ' +
+          (p.Slices || []).length + ' slices';
+      }).join('\n'));
+
+      var a = document.createElement('a');
+      a.href = data.Body;
+      a.textContent = 'Download trace (go tool trace trace.out)';
+      output.appendChild(a);
+    },
+
+    run: function (data) {
+      output.innerHTML = '';
+      clearLiveValues();
+    },
+
+    value: function (data) {
+      var v = data.Args && data.Args[0];
+      if (!v) { return; }
+      var line = liveValues[v.Line] || (liveValues[v.Line] = {names: [], values: {}, count: 0});
+      var name = v.Name || '>';
+      if (!(name in line.values)) { line.names.push(name); }
+      line.values[name] = v.Name ? v.Value : v.Value.replace(/\n$/, '');
+      line.count++;
+      showLiveValues();
+    },
+
+    output: function (data) {
+      appendSegments(data.Args && data.Args[1], data.Args && data.Args[0]);
+    },
+
+    exit: function (data) {
+      var s = data.Args && data.Args[0];
+      setOutput(data.Body);
+      if (s) {
+        // Playground runs only have the wall time.
+        var parts = ['wall ' + ms(s.Wall)];
+        if (s.User || s.System || s.MaxRSS) {
+          parts.push('user ' + ms(s.User), 'sys ' + ms(s.System), 'max rss ' + Math.round(s.MaxRSS / 1024) + 'kB');
+        }
+        if (s.NumGC) {
+          parts.push(s.NumGC + ' GCs (' + ms(s.GCPause) + ' paused)');
+        }
+        setOutput(parts.join(', '));
+      }
+    },
+
+    history: function (data) {
+      var runs = (data.Args && data.Args[0]) || [];
+      if (data.Body !== 'append') {
+        runHistory = [];
+      }
+      runs.forEach(function (r) {
+        runHistory.push(r);
+        setChatText('run #' + r.ID + ' by ' + (r.User || '?') + ' at ' +
+          new Date(r.Started).toLocaleTimeString() + ': exit ' + r.ExitCode + ' (' + ms(r.Duration) + ')');
+      });
+    },
+
+    'history-diff': function (data) {
+      setOutput('Output of run #' + data.Args[0] + ' compared to run #' + data.Args[1] + ':', true);
+      setOutput(data.Body);
+    },
+
+    complete: function (data) {
+      var p = pendingCompletion;
+      if (!p || data.Args[1] !== p.offset) { return; }
+      pendingCompletion = null;
+      p.callback(null, (data.Args[0] || []).map(function (c) {
+        return {
+          caption: c.Name,
+          value: c.Name,
+          meta: c.Kind,
+          docHTML: '<b>' + escapeHTML(c.Name + ' ' + (c.Type || '')) + '</b>' +
+            (c.Doc ? '<p>' + escapeHTML(c.Doc) + '</p>' : '')
+        };
+      }));
+    },
+
+    hover: function (data) {
+      var p = pendingHover;
+      if (!p || data.Args[1] !== p.offset) { return; }
+      pendingHover = null;
+      var h = data.Args[0];
+      if (!h) {
+        if (p.x === undefined) { setOutput('No information at the cursor'); }
+        return;
+      }
+      var head = h.Decl || (h.Expr + (h.Type ? ' ' + h.Type : ''));
+      if (h.Decl && h.Type && h.Decl.indexOf(h.Type) < 0) {
+        head += '\n' + h.Expr + ': ' + h.Type;
+      }
+      if (p.x === undefined) {
+        setOutput(head + (h.Doc ? '\n\n' + h.Doc : ''), true);
+        return;
+      }
+      hoverTooltip.setHtml('<b>' + escapeHTML(head) + '</b>' +
+        (h.Doc ? '<p>' + escapeHTML(h.Doc) + '</p>' : ''));
+      hoverTooltip.show(null, p.x + 10, p.y + 10);
+    },
+
+    definition: function (data) {
+      var l = data.Args[0];
+      if (!l) {
+        setOutput('No definition found');
+      } else if (l.File) {
+        showSource(l);
+      } else {
+        jumpTo(l);
+      }
+    },
+
+    references: function (data) {
+      var refs = data.Args[0] || [];
+      setOutput(refs.length + ' references:', true);
+      refs.forEach(function (l) {
+        addOutputLink(l.Line + ':' + l.Column + '\t' + editor.getSession().getLine(l.Line - 1).trim(), l);
+      });
+    },
+
+    outline: function (data) {
+      var syms = data.Args[0] || [];
+      setOutput('Outline:', true);
+      syms.forEach(function (s) {
+        var name = s.Recv ? '(' + s.Recv + ') ' + s.Name : s.Name;
+        addOutputLink(s.Kind + ' ' + name + (s.Type ? ' ' + s.Type : ''), s);
+      });
+    },
+
+    'format-options': function (data) {
+      var o = data.Args && data.Args[0];
+      if (!o) { return; }
+      if (formatOptions) {
+        setChatText('Formatting: tab width ' + o.TabWidth + (o.TabIndent ? ', tabs' : ', spaces') +
+          (o.Comments ? '' : ', no comments') + (o.FormatOnly ? ', no import fixes' : '') +
+          (o.Simplify ? ', simplified' : '') +
+          (o.LocalPrefixes && o.LocalPrefixes.length ? ', local ' + o.LocalPrefixes.join(',') : ''));
+      }
+      formatOptions = o;
+    },
+
+    sharing: function (data) {
+      var sh = data.Args && data.Args[0];
+      if (!sh) { return; }
+      setChatText('Room owned by ' + (data.Body || 'nobody') + ', results are ' +
+        (sh.Scope === 'room' ? 'shared' : 'private') + ' by default' +
+        (sh.Restricted ? ', only ' + [data.Body].concat(sh.Allowed || []).join(', ') + ' may share' : ''));
+      sharing = sh;
     },
 
     gist: function (data) {
@@ -54,9 +391,11 @@
 
     update: function (data) {
       if (Array.isArray(data.Args) && data.Args[0] !== clientId)  {
+        // The text is often already there, like after formatting.
+        if (data.Body === editor.getValue()) { return; }
         editor.getSession().off('change', changeText);
 
-        setText(data.Body, true);
+        replaceChanged(data.Body);
 
         editor.getSession().on('change', changeText);
       }
@@ -98,6 +437,7 @@
 
     send: function (ws, payload) {
       if (ws && ws.readyState === WebSocket.OPEN) {
+        if (scope) { payload.Scope = scope; }
         ws.send(JSON.stringify(payload));
       }
     }
@@ -109,7 +449,12 @@
     initSocket();
     // UI event handlers
     gistBtn.addEventListener('click', saveCode, false);
+    testBtn.addEventListener('click', testCode, false);
+    toolchainSelect.addEventListener('change', function () {
+      sendMessage('toolchain', toolchainSelect.value);
+    }, false);
     chatInput.addEventListener('keydown', sendChatMessage, false);
+    stdinInput.addEventListener('keydown', sendStdin, false);
   }
 
   function initEditor() {
@@ -124,6 +469,38 @@
     editor.setShowInvisibles(false);
     editor.setShowPrintMargin(false);
     editor.setKeyboardHandler('ace/keyboard/vim');
+    // Completions come from the server, which type-checks the document.
+    ace.require('ace/ext/language_tools');
+    editor.completers = [{
+      // Import paths are completed as a whole, members after the dot.
+      identifierRegexps: [/[a-zA-Z_0-9\/\u00A2-\uFFFF]/],
+      getCompletions: function (ed, session, pos, prefix, callback) {
+        var offset = session.getDocument().positionToIndex(pos);
+        pendingCompletion = { offset: offset, callback: callback };
+        sendMessage('complete', session.getValue(), [offset]);
+      }
+    }];
+    editor.setOptions({ enableBasicAutocompletion: true, enableLiveAutocompletion: true });
+
+    // Resting the mouse on a name shows its type and doc.
+    hoverTooltip = new (ace.require('ace/tooltip').Tooltip)(document.body);
+    editor.on('mousemove', function (e) {
+      clearTimeout(hoverTimer);
+      hoverTooltip.hide();
+      var pos = e.getDocumentPosition();
+      var x = e.clientX, y = e.clientY;
+      hoverTimer = setTimeout(function () {
+        requestHover(pos, x, y);
+      }, 500);
+    });
+    editor.container.addEventListener('mouseleave', function () {
+      clearTimeout(hoverTimer);
+      pendingHover = null;
+      hoverTooltip.hide();
+    }, false);
+    editor.on('change', function () { hoverTooltip.hide(); });
+    // Notes are for the text they were made for.
+    editor.on('change', function () { editor.getSession().clearAnnotations(); });
     // Hide gutter
     editor.renderer.setShowGutter(false);
 
@@ -139,11 +516,145 @@
       }
     });
 
+    editor.commands.addCommand({
+      name: 'gotoDefinition',
+      bindKey: { win: 'F12', mac: 'F12', sender: 'editor|cli' },
+      exec: function (env) {
+        sendAtCursor('definition');
+      }
+    });
+
+    editor.commands.addCommand({
+      name: 'formatSelection',
+      bindKey: { win: 'Ctrl-Shift-F', mac: 'Command-Shift-F', sender: 'editor|cli' },
+      exec: function (env) {
+        var r = env.getSelectionRange();
+        sendMessage('format', env.getValue(), [r.start.row, r.end.row]);
+      }
+    });
+
     ace.config.loadModule('ace/keyboard/vim', function(m) {
       var vim = ace.require('ace/keyboard/vim').CodeMirror.Vim;
       vim.defineEx('write', 'w', function(cm, input) {
         cm.ace.execCommand('saveFile');
       });
+      vim.defineEx('format', 'format', function(cm, input) {
+        if (input.line === undefined) {
+          cm.ace.execCommand('formatSelection');
+          return;
+        }
+        var end = input.lineEnd === undefined ? input.line : input.lineEnd;
+        sendMessage('format', editor.getValue(), [input.line, end]);
+      });
+      vim.defineEx('test', 'test', function(cm, input) {
+        testCode();
+      });
+      vim.defineEx('examples', 'examples', function(cm, input) {
+        sendMessage('examples', editor.getValue());
+      });
+      vim.defineEx('fuzz', 'fuzz', function(cm, input) {
+        var args = input.args || [];
+        sendMessage('fuzz', editor.getValue(), [args[0] || '', parseInt(args[1], 10) || 0]);
+      });
+      vim.defineEx('matrix', 'matrix', function(cm, input) {
+        sendMessage('build-matrix', editor.getValue());
+      });
+      vim.defineEx('def', 'def', function(cm, input) {
+        sendAtCursor('definition');
+      });
+      vim.defineEx('refs', 'refs', function(cm, input) {
+        sendAtCursor('references');
+      });
+      vim.defineEx('outline', 'outline', function(cm, input) {
+        sendAtCursor('outline');
+      });
+      vim.defineEx('doc', 'doc', function(cm, input) {
+        requestHover(editor.getCursorPosition());
+      });
+      vim.defineEx('vet', 'vet', function(cm, input) {
+        sendMessage('vet', editor.getValue());
+      });
+      vim.defineEx('asm', 'asm', function(cm, input) {
+        sendMessage('asm', editor.getValue());
+      });
+      vim.defineEx('optimizations', 'opt', function(cm, input) {
+        sendMessage('optimizations', editor.getValue());
+      });
+      vim.defineEx('profile', 'prof', function(cm, input) {
+        sendMessage('profile', editor.getValue(), [(input.args || [])[0] || '']);
+      });
+      vim.defineEx('trace', 'trace', function(cm, input) {
+        sendMessage('trace', editor.getValue(), [(input.args || [])[0] || '']);
+      });
+      vim.defineEx('live', 'live', function(cm, input) {
+        sendMessage('live', editor.getValue(), [configSelect.value]);
+      });
+      vim.defineEx('autorun', 'autorun', function(cm, input) {
+        var args = input.args || [];
+        var a = {
+          Enabled: args[0] === 'on' || args[0] === 'live',
+          Live: args[0] === 'live',
+          Delay: parseInt(args[1], 10) || (autoRun && autoRun.Delay) || 1000,
+          Config: configSelect.value
+        };
+        sendMessage('autorun', '', [a]);
+      });
+      // :fmt tabwidth=4 spaces|tabs comments|nocomments imports|noimports
+      //      simplify|nosimplify local=prefix,...
+      vim.defineEx('fmt', 'fmt', function(cm, input) {
+        var o = JSON.parse(JSON.stringify(formatOptions || {}));
+        (input.args || []).forEach(function (arg) {
+          var kv = arg.split('=');
+          switch (kv[0]) {
+            case 'tabwidth': o.TabWidth = parseInt(kv[1], 10) || 0; break;
+            case 'tabs': o.TabIndent = true; break;
+            case 'spaces': o.TabIndent = false; break;
+            case 'comments': o.Comments = true; break;
+            case 'nocomments': o.Comments = false; break;
+            case 'imports': o.FormatOnly = false; break;
+            case 'noimports': o.FormatOnly = true; break;
+            case 'simplify': o.Simplify = true; break;
+            case 'nosimplify': o.Simplify = false; break;
+            case 'local': o.LocalPrefixes = kv[1] ? kv[1].split(',') : []; break;
+          }
+        });
+        sendMessage('format-options', '', [o]);
+      });
+      vim.defineEx('scope', 'scope', function(cm, input) {
+        var s = (input.args || [])[0] || '';
+        scope = s === 'default' ? '' : s;
+        setChatText('Your results are ' + (scope ? scope : 'sent as the room defaults'));
+      });
+      vim.defineEx('sharing', 'sharing', function(cm, input) {
+        var args = input.args || [];
+        var sh = {
+          Scope: args[0] || (sharing && sharing.Scope) || 'room',
+          Restricted: args[1] === 'only',
+          Allowed: args[1] === 'only' ? args.slice(2) : []
+        };
+        sendMessage('sharing', '', [sh]);
+      });
+      vim.defineEx('kill', 'kill', function(cm, input) {
+        sendMessage('kill', '');
+      });
+      vim.defineEx('history', 'his', function(cm, input) {
+        var id = parseInt((input.args || [])[0], 10);
+        if (isNaN(id)) {
+          sendMessage('history', '');
+          return;
+        }
+        showRun(id);
+      });
+      vim.defineEx('diff', 'diff', function(cm, input) {
+        var args = input.args || [];
+        sendMessage('history', '', [parseInt(args[0], 10) || 0, parseInt(args[1], 10) || 0]);
+      });
+      vim.defineEx('cancel', 'cancel', function(cm, input) {
+        sendMessage('cancel', '');
+      });
+      vim.defineEx('compare', 'compare', function(cm, input) {
+        sendMessage('compare', editor.getValue(), [configSelect.value]);
+      });
     });
 
     editor.getSession().on('change', changeText);
@@ -170,20 +681,75 @@
     }
   }
 
+  // sendAtCursor sends a request about the position of the cursor.
+  function sendAtCursor(kind) {
+    var offset = editor.getSession().getDocument().positionToIndex(editor.getCursorPosition());
+    sendMessage(kind, editor.getValue(), [offset]);
+  }
+
+  // jumpTo selects a location of the document.
+  function jumpTo(l) {
+    var Range = ace.require('ace/range').Range;
+    editor.selection.setRange(new Range(l.Line - 1, l.Column - 1, l.Line - 1, l.Column - 1));
+    editor.scrollToLine(l.Line - 1, true, true);
+    editor.focus();
+  }
+
+  // addOutputLink adds a line to the output that jumps to a location
+  // of the document when clicked.
+  function addOutputLink(txt, l) {
+    var el = document.createElement('pre');
+    el.classList.add('text', 'link');
+    el.textContent = txt;
+    el.addEventListener('click', function () { jumpTo(l); }, false);
+    output.appendChild(el);
+  }
+
+  // showSource shows a file of the standard library read-only in the
+  // output, at the location of a definition.
+  function showSource(l) {
+    setOutput(l.File + ':' + l.Line + ' (read-only)', true);
+    var el = document.createElement('div');
+    el.classList.add('source');
+    output.appendChild(el);
+    var viewer = ace.edit(el);
+    viewer.$blockScrolling = Infinity;
+    viewer.setTheme('ace/theme/vibrant_ink');
+    viewer.getSession().setMode('ace/mode/golang');
+    viewer.setReadOnly(true);
+    viewer.setShowPrintMargin(false);
+    viewer.setValue(l.Source, -1);
+    var Range = ace.require('ace/range').Range;
+    viewer.selection.setRange(new Range(l.Line - 1, l.Column - 1, l.EndLine - 1, l.EndColumn - 1));
+    viewer.scrollToLine(l.Line - 1, true, false);
+  }
+
+  // requestHover asks what is at pos of the document, to show at x, y
+  // or in the output.
+  function requestHover(pos, x, y) {
+    var offset = editor.getSession().getDocument().positionToIndex(pos);
+    pendingHover = { offset: offset, x: x, y: y };
+    sendMessage('hover', editor.getValue(), [offset]);
+  }
+
   function sendMessage(kind, body, args) {
     if (ws && ws.readyState === WebSocket.OPEN) {
-      ws.send(JSON.stringify({ Id: 'gopher-gala-2015@julienc', Kind: kind, Body: body, Args: args }));
+      ws.send(JSON.stringify({ Id: 'gopher-gala-2015@julienc', Kind: kind, Body: body, Args: args, Scope: scope || undefined }));
     }
   }
 
   function sendCode(src) {
-    wsCtrl.send(ws, { Id: defaultClientId, Kind: 'compile', Body: src });
+    wsCtrl.send(ws, { Id: defaultClientId, Kind: 'compile', Body: src, Args: [configSelect.value] });
   }
 
   function saveCode() {
     wsCtrl.send(ws, { Id: defaultClientId, Kind: 'save', Body: editor.getValue() });
   }
 
+  function testCode() {
+    wsCtrl.send(ws, { Id: defaultClientId, Kind: 'test', Body: editor.getValue() });
+  }
+
   function sendChatMessage(e) {
     if (e.keyCode === 13 && e.currentTarget.value) {
       var txt = e.currentTarget.value;
@@ -192,6 +758,17 @@
     }
   }
 
+  function sendStdin(e) {
+    if (e.keyCode === 13) {
+      var txt = e.currentTarget.value;
+      e.currentTarget.value = '';
+      sendMessage('stdin', txt + '\n');
+    } else if (e.ctrlKey && e.keyCode === 68) {
+      e.preventDefault();
+      sendMessage('stdin', '', ['eof']);
+    }
+  }
+
   function setChatText(str) {
     chatTxt.value += str + '\n';
     chatTxt.scrollTop = chatTxt.scrollHeight - chatTxt.offsetHeight;
@@ -207,10 +784,201 @@
     editor.setReadOnly(false);
   }
 
+  // replaceChanged sets the text of the editor to str by replacing only
+  // the lines between the start and end both have in common, so that
+  // the cursors outside of them don't move.
+  function replaceChanged(str) {
+    var doc = editor.getSession().getDocument();
+    var a = doc.getAllLines();
+    var b = str.split('\n');
+    var pre = 0;
+    while (pre < a.length && pre < b.length && a[pre] === b[pre]) { pre++; }
+    var suf = 0;
+    while (suf < a.length - pre && suf < b.length - pre &&
+        a[a.length - 1 - suf] === b[b.length - 1 - suf]) {
+      suf++;
+    }
+
+    // The changed lines, without the newline of the last one as the
+    // text may end there.
+    var end = a.length - suf;
+    var range = {
+      start: { row: pre, column: 0 },
+      end: end > pre ? { row: end - 1, column: a[end - 1].length } : { row: pre, column: 0 }
+    };
+    var text = b.slice(pre, b.length - suf).join('\n');
+    if (end === pre && b.length - suf > pre) {
+      // Only added lines, they go before line pre or after the last.
+      if (pre < a.length) {
+        text += '\n';
+      } else {
+        range.start = range.end = { row: pre - 1, column: a[pre - 1].length };
+        text = '\n' + text;
+      }
+    } else if (end > pre && b.length - suf === pre) {
+      // Only removed lines, their newlines go too.
+      range.end = end < a.length ? { row: end, column: 0 } : range.end;
+      if (end === a.length && pre > 0) {
+        range.start = { row: pre - 1, column: a[pre - 1].length };
+      }
+    }
+    doc.replace(range, text);
+    if (editor.getValue() !== str) {
+      setText(str, true);
+    }
+  }
+
+  // applyEdits applies line edits to the editor, which keeps the
+  // cursors where they are. It reports false if the result isn't want,
+  // as the text changed since it was formatted.
+  function applyEdits(edits, want) {
+    var doc = editor.getSession().getDocument();
+    for (var i = edits.length - 1; i >= 0; i--) {
+      var e = edits[i];
+      var text = (e.Lines || []).join('\n');
+      var n = doc.getLength();
+      var end = e.Line + e.Delete;
+      if (end > n) { return false; }
+
+      var range;
+      if (end < n) {
+        // Whole lines with their newlines.
+        range = { start: { row: e.Line, column: 0 }, end: { row: end, column: 0 } };
+        text += e.Lines && e.Lines.length ? '\n' : '';
+      } else if (e.Delete === 0 || !(e.Lines && e.Lines.length)) {
+        // At the end, the newline before the lines goes too.
+        if (e.Line === 0) {
+          range = { start: { row: 0, column: 0 }, end: { row: n - 1, column: doc.getLine(n - 1).length } };
+        } else {
+          range = {
+            start: { row: e.Line - 1, column: doc.getLine(e.Line - 1).length },
+            end: { row: n - 1, column: doc.getLine(n - 1).length }
+          };
+          text = e.Lines && e.Lines.length ? '\n' + text : '';
+        }
+      } else {
+        range = { start: { row: e.Line, column: 0 }, end: { row: n - 1, column: doc.getLine(n - 1).length } };
+      }
+      doc.replace(range, text);
+    }
+    return editor.getValue() === want;
+  }
+
+  function clearMarkers() {
+    var session = editor.getSession();
+    markers.forEach(function (id) { session.removeMarker(id); });
+    markers = [];
+  }
+
+  function clearLiveValues() {
+    liveValues = {};
+    showLiveValues();
+  }
+
+  // showLiveValues draws the values of a live run at the end of the
+  // lines that produced them.
+  function showLiveValues() {
+    var session = editor.getSession();
+    if (!liveMarker) {
+      liveMarker = session.addDynamicMarker({
+        update: function (html, layer, session, config) {
+          for (var row = config.firstRow; row <= config.lastRow; row++) {
+            var line = liveValues[row + 1];
+            if (!line) { continue; }
+            var text = line.names.map(function (name) {
+              return name === '>' ? '> ' + line.values[name] : name + ' = ' + line.values[name];
+            }).join(', ') + (line.count > line.names.length ? ' (' + line.count + 'x)' : '');
+            var top = layer.$getTop(session.documentToScreenRow(row, 0), config);
+            var left = layer.$padding + (session.getLine(row).length + 4) * config.characterWidth;
+            html.push('<div class="live-value" style="top:', top, 'px;left:', left, 'px;height:',
+              config.lineHeight, 'px">', escapeHTML(text), '</div>');
+          }
+        }
+      }, true);
+    }
+    session._signal('changeFrontMarker');
+  }
+
+  function escapeHTML(s) {
+    return s.replace(/&/g, '&amp;').replace(/</g, '&lt;').replace(/>/g, '&gt;').replace(/"/g, '&quot;');
+  }
+
+  // annotate shows notes, with a Line, Col and Message, in the gutter of
+  // their lines. Notes for the same line are shown together.
+  function annotate(notes, type) {
+    editor.getSession().setAnnotations(notes.map(function (n) {
+      return { row: n.Line - 1, column: Math.max((n.Col || 1) - 1, 0), text: n.Message, type: type };
+    }));
+  }
+
+  function addMarkers(ranges, cls) {
+    var Range = ace.require('ace/range').Range;
+    var session = editor.getSession();
+    (ranges || []).forEach(function (r) {
+      var range = new Range(r.StartLine - 1, r.StartCol - 1, r.EndLine - 1, r.EndCol - 1);
+      markers.push(session.addMarker(range, cls, 'text'));
+    });
+  }
+
+  // showRun shows the output of a run of the history.
+  function showRun(id) {
+    var run = runHistory.filter(function (r) { return r.ID === id; })[0];
+    if (!run) {
+      setOutput('Run #' + id + ' is not in the history', true);
+      return;
+    }
+    setOutput('Run #' + run.ID + ' of ' + run.Source + ' by ' + (run.User || '?') + ':', true);
+    setOutput(run.Output + (run.Truncated ? '\n[output truncated]' : ''));
+    setOutput(run.Status);
+  }
+
+  function ms(ns) {
+    return (ns / 1e6).toFixed(1) + 'ms';
+  }
+
+  // appendSegments adds the segments of program output, which the
+  // server parsed into text, images and sanitized HTML.
+  function appendSegments(segs, stream) {
+    var pre = output.lastElementChild;
+    if (!pre || !pre.classList.contains('stream')) {
+      pre = document.createElement('pre');
+      pre.classList.add('text', 'stream');
+      output.appendChild(pre);
+    }
+
+    (segs || []).forEach(function (seg) {
+      var el;
+      if (seg.Kind === 'image') {
+        el = document.createElement('img');
+        el.src = 'data:' + seg.MIME + ';base64,' + seg.Data;
+      } else if (seg.Kind === 'html') {
+        el = document.createElement('div');
+        el.innerHTML = seg.Text;
+      } else {
+        el = document.createElement('span');
+        el.textContent = seg.Text;
+        setStyle(el, seg.Style);
+      }
+      el.classList.add(stream);
+      pre.appendChild(el);
+    });
+    output.scrollTop = output.scrollHeight - output.offsetHeight;
+  }
+
+  function setStyle(el, style) {
+    if (!style) { return; }
+    el.style.color = style.Color || '';
+    el.style.backgroundColor = style.Background || '';
+    el.style.fontWeight = style.Bold ? 'bold' : '';
+    el.style.opacity = style.Faint ? '0.7' : '';
+    el.style.fontStyle = style.Italic ? 'italic' : '';
+    el.style.textDecoration = style.Underline ? 'underline' : '';
+  }
+
   function setOutput(txt, empty) {
     var el = document.createElement('pre');
     el.classList.add('text');
-    el.innerHTML += txt;
+    el.textContent = txt;
 
     if (empty) { output.innerHTML = ''; }
     output.appendChild(document.createDocumentFragment().appendChild(el));